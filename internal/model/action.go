@@ -0,0 +1,75 @@
+package model
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+// World is the part of the maze an octapod needs to resolve its actions
+type World interface {
+	Grid() pkg.Grid
+	IsAvailable(point pkg.Vector) bool
+	// CanEnter reports whether a step from one cell into the next is allowed
+	CanEnter(from pkg.Vector, to pkg.Vector) bool
+	// StopsDash reports whether a dash ends on the cell, so the tile there acts on the octapod
+	StopsDash(point pkg.Vector) bool
+}
+
+// ActionRules is the per-tick action point budget and the cost of each action
+type ActionRules struct {
+	Points   int `json:"points"`
+	DashCost int `json:"dashCost"`
+}
+
+func (r ActionRules) Cost(m *MoveMessage) int {
+	switch m.GetAction() {
+	case Move, Scan:
+		return 1
+	case Dash:
+		return m.Distance * r.DashCost
+	default:
+		return 0
+	}
+}
+
+// resolve returns where the action takes the octapod, or false when it is not allowed
+func (r ActionRules) resolve(m *MoveMessage, position pkg.Vector, world World) (pkg.Vector, bool) {
+	grid := world.Grid()
+	if !m.IsValid(grid) || r.Cost(m) > r.Points {
+		return position, false
+	}
+
+	direction, _ := m.DirectionOn(grid.Topology)
+
+	switch m.GetAction() {
+	case Move:
+		target, ok := grid.Step(position, direction)
+		if !ok || !world.CanEnter(position, target) {
+			return position, false
+		}
+		// No cutting corners, both orthogonal cells must be open. Hex neighbours share an edge.
+		if grid.Topology != pkg.Hex && m.IsDiagonal() {
+			horizontal, _ := grid.Step(position, pkg.Vec2(direction.X, 0))
+			vertical, _ := grid.Step(position, pkg.Vec2(0, direction.Y))
+			if !world.IsAvailable(horizontal) || !world.IsAvailable(vertical) {
+				return position, false
+			}
+		}
+		return target, true
+	case Dash:
+		// The dash is paid in full even when a hazard cuts it short
+		target := position
+		for i := 0; i < m.Distance; i++ {
+			next, ok := grid.Step(target, direction)
+			if !ok || !world.CanEnter(target, next) {
+				return position, false
+			}
+			target = next
+			if world.StopsDash(target) {
+				break
+			}
+		}
+		return target, true
+	default:
+		return position, true
+	}
+}
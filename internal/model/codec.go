@@ -0,0 +1,65 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/ugorji/go/codec"
+)
+
+// Codec encodes the messages of a connection. Websocket octapods choose it by subprotocol at join,
+// the other transports are JSON only.
+type Codec interface {
+	Encode(message any) ([]byte, error)
+	Decode(data []byte, message any) error
+	// Binary reports whether the frames are binary rather than text
+	Binary() bool
+}
+
+const (
+	JsonSubprotocol    = "json"
+	MsgpackSubprotocol = "msgpack"
+)
+
+// Subprotocols lists the encodings offered at join, the preferred one first
+var Subprotocols = []string{MsgpackSubprotocol, JsonSubprotocol}
+
+// CodecFor returns the codec for the agreed subprotocol, JSON when the client asked for none
+func CodecFor(subprotocol string) Codec {
+	if subprotocol == MsgpackSubprotocol {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(message any) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Decode(data []byte, message any) error {
+	return json.Unmarshal(data, message)
+}
+
+func (jsonCodec) Binary() bool {
+	return false
+}
+
+// msgpackHandle is shared by every connection, it must not be changed once in use.
+// Field names and omitempty are taken from the json tags, so both encodings carry the same keys.
+var msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(message any) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(message)
+	return data, err
+}
+
+func (msgpackCodec) Decode(data []byte, message any) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(message)
+}
+
+func (msgpackCodec) Binary() bool {
+	return true
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+// Exploration is the part of the maze an octapod has seen this round, each cell as last seen
+type Exploration struct {
+	cells map[pkg.Vector]pkg.Cell
+}
+
+func NewExploration() *Exploration {
+	return &Exploration{
+		cells: make(map[pkg.Vector]pkg.Cell),
+	}
+}
+
+// Record adds the seen cells and returns the ones seen for the first time,
+// or changed since last seen by a door, a wall shift or a collected tile
+func (e *Exploration) Record(seen []pkg.Cell) []pkg.Cell {
+	discovered := make([]pkg.Cell, 0, len(seen))
+	for _, cell := range seen {
+		if known, ok := e.cells[cell.Vector]; ok && known == cell {
+			continue
+		}
+		e.cells[cell.Vector] = cell
+		discovered = append(discovered, cell)
+	}
+	return discovered
+}
+
+func (e *Exploration) Cells() []pkg.Cell {
+	cells := make([]pkg.Cell, 0, len(e.cells))
+	for _, cell := range e.cells {
+		cells = append(cells, cell)
+	}
+	pkg.SortCells(cells)
+	return cells
+}
+
+// OpenCount returns the number of discovered cells that are not walls
+func (e *Exploration) OpenCount() int {
+	count := 0
+	for _, cell := range e.cells {
+		if !cell.Wall {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,18 @@
+package model
+
+import "gbccsclub/octopod-challenge/pkg"
+
+// Inventory is what an octapod picked up this round
+type Inventory struct {
+	Coins  int  `json:"coins"`
+	HasKey bool `json:"hasKey"`
+}
+
+func (i *Inventory) collect(kind pkg.TileKind) {
+	switch kind {
+	case pkg.Coin:
+		i.Coins++
+	case pkg.Key:
+		i.HasKey = true
+	}
+}
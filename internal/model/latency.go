@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+)
+
+// Latency sums up how long the octapod took to answer its pings this round, late moves included
+type Latency struct {
+	Count int           `json:"count"`
+	Last  time.Duration `json:"last"`
+	Max   time.Duration `json:"max"`
+	Total time.Duration `json:"total"`
+}
+
+func (l *Latency) record(latency time.Duration) {
+	l.Count++
+	l.Last = latency
+	l.Max = max(l.Max, latency)
+	l.Total += latency
+}
+
+func (l Latency) Average() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
@@ -11,17 +11,112 @@ const (
 	Down  MoveDirection = "Down"
 	Left  MoveDirection = "Left"
 	Right MoveDirection = "Right"
+
+	UpLeft    MoveDirection = "UpLeft"
+	UpRight   MoveDirection = "UpRight"
+	DownLeft  MoveDirection = "DownLeft"
+	DownRight MoveDirection = "DownRight"
+
+	// Ascend and Descend take the stairs in multi-level mazes
+	Ascend  MoveDirection = "Ascend"
+	Descend MoveDirection = "Descend"
+)
+
+type Action string
+
+const (
+	// Move takes a single step, cardinal, diagonal or up and down the stairs
+	Move Action = "Move"
+	// Dash takes Distance steps in a cardinal direction, or any direction on a hex grid.
+	// It stops early on mud, ice, teleporters and traps, which then act as on a single step.
+	Dash Action = "Dash"
+	// Wait stays in place
+	Wait Action = "Wait"
+	// Scan stays in place and reveals the cells around the octapod in the next ping
+	Scan Action = "Scan"
 )
 
 type MoveMessage struct {
 	TickId        string        `json:"tickId"`
 	MoveDirection MoveDirection `json:"moveDirection"`
+
+	// Action defaults to Move for older bots
+	Action Action `json:"action,omitempty"`
+	// Distance is the number of cells to dash, at most the width or height of the maze
+	Distance int `json:"distance,omitempty"`
+
+	// RequestMap asks for the full discovered map in the next ping
+	RequestMap bool `json:"requestMap,omitempty"`
+
+	// TeamMessage and TeamCells are relayed to teammates in team mode
+	TeamMessage string     `json:"teamMessage,omitempty"`
+	TeamCells   []pkg.Cell `json:"teamCells,omitempty"`
+}
+
+func (m *MoveMessage) GetAction() Action {
+	if m.Action == "" {
+		return Move
+	}
+	return m.Action
+}
+
+// IsValid checks the action can be played on the grid.
+// Dashes are bounded by the size of the grid, so their cost can't overflow and their steps stay few.
+func (m *MoveMessage) IsValid(grid pkg.Grid) bool {
+	topology := grid.Topology
+	_, hasDirection := m.DirectionOn(topology)
+
+	switch m.GetAction() {
+	case Move:
+		return hasDirection
+	case Dash:
+		return hasDirection && !m.IsVertical() && (topology == pkg.Hex || m.IsCardinal()) &&
+			m.Distance >= 1 && m.Distance <= max(grid.Width, grid.Height)
+	case Wait, Scan:
+		return true
+	default:
+		return false
+	}
 }
 
-func (m *MoveMessage) IsValid() bool {
+func (m *MoveMessage) IsCardinal() bool {
 	return m.MoveDirection == Up || m.MoveDirection == Down || m.MoveDirection == Left || m.MoveDirection == Right
 }
 
+func (m *MoveMessage) IsDiagonal() bool {
+	return m.MoveDirection == UpLeft || m.MoveDirection == UpRight || m.MoveDirection == DownLeft || m.MoveDirection == DownRight
+}
+
+// DirectionOn returns the step taken by the direction on the topology.
+// Hex grids have no Up and Down, the diagonals point to the neighbours above and below.
+func (m *MoveMessage) DirectionOn(topology pkg.Topology) (pkg.Vector, bool) {
+	if m.IsVertical() || topology != pkg.Hex {
+		direction := m.ToVector()
+		return direction, direction != pkg.ZeroVec2()
+	}
+
+	switch m.MoveDirection {
+	case Left:
+		return pkg.Vec2(-1, 0), true
+	case Right:
+		return pkg.Vec2(1, 0), true
+	case UpLeft:
+		return pkg.Vec2(0, -1), true
+	case UpRight:
+		return pkg.Vec2(1, -1), true
+	case DownLeft:
+		return pkg.Vec2(-1, 1), true
+	case DownRight:
+		return pkg.Vec2(0, 1), true
+	default:
+		return pkg.ZeroVec2(), false
+	}
+}
+
+func (m *MoveMessage) IsVertical() bool {
+	return m.MoveDirection == Ascend || m.MoveDirection == Descend
+}
+
 func (m *MoveMessage) ToVector() pkg.Vector {
 	switch m.MoveDirection {
 	case Up:
@@ -32,6 +127,18 @@ func (m *MoveMessage) ToVector() pkg.Vector {
 		return pkg.Vec2(-1, 0)
 	case Right:
 		return pkg.Vec2(1, 0)
+	case UpLeft:
+		return pkg.Vec2(-1, -1)
+	case UpRight:
+		return pkg.Vec2(1, -1)
+	case DownLeft:
+		return pkg.Vec2(-1, 1)
+	case DownRight:
+		return pkg.Vec2(1, 1)
+	case Ascend:
+		return pkg.Vec3(0, 0, 1)
+	case Descend:
+		return pkg.Vec3(0, 0, -1)
 	default:
 		return pkg.ZeroVec2()
 	}
@@ -1,11 +1,11 @@
 package model
 
 import (
-	"encoding/json"
 	"gbccsclub/octopod-challenge/pkg"
-	"github.com/gorilla/websocket"
 	"log"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type Octapod struct {
@@ -14,74 +14,333 @@ type Octapod struct {
 	moveMsg      *MoveMessage
 	sensor       *pkg.Sensor
 	tickId       string
+	exploration  *Exploration
+	mapRequested bool
+
+	scanRequested bool
+	inventory     Inventory
+	stuck         int
+	idleTicks     int
+
+	// pingedAt is when the last ping was sent, the first move of the tick is timed from it
+	pingedAt       time.Time
+	timed          bool
+	responseWindow time.Duration
+	latency        Latency
+
+	teamOutbox *TeamMessage
+	teamInbox  []TeamMessage
+
+	// version is the protocol version agreed on in the hello, 0 until then
+	version int
+	// writer writes the encoded messages to the transport, see writer.go
+	writer *Writer
+	// errors counts the messages dropped since the octapod joined, by code
+	errors map[ErrorCode]int
+	// grid is the grid of the round, moves are checked against it without asking the handler
+	grid pkg.Grid
 
 	id           string
+	team         string
 	position     pkg.Vector
-	conn         *websocket.Conn
+	transport    Transport
+	codec        Codec
+	roundInfo    func() RoundInfo
+	onMove       func(id string)
 	onDisconnect func(id string)
 }
 
-func NewOctapod(id string, position pkg.Vector, conn *websocket.Conn, onDisconnect func(id string)) *Octapod {
+func NewOctapod(id string, team string, position pkg.Vector, transport Transport, codec Codec, roundInfo func() RoundInfo, onMove func(id string), onDisconnect func(id string)) *Octapod {
 	return &Octapod{
 		moveReceived: false,
 		moveMsg:      nil,
 		sensor:       pkg.NewSensor(true, true, true, true),
+		exploration:  NewExploration(),
+		errors:       make(map[ErrorCode]int),
+		writer:       NewWriter(id, transport),
 		id:           id,
+		team:         team,
 		position:     position,
-		conn:         conn,
+		transport:    transport,
+		codec:        codec,
+		roundInfo:    roundInfo,
+		onMove:       onMove,
 		onDisconnect: onDisconnect,
 	}
 }
 
 func (o *Octapod) Run() {
 	go o.readLoop()
+	go o.writer.Run()
 }
 
-func (o *Octapod) Ping(tickId string, sensor *pkg.Sensor, status Status) error {
+// Ping starts a new tick. Seen holds the cells the octapod sees, for its exploration.
+func (o *Octapod) Ping(tickId string, sensor *pkg.Sensor, seen []pkg.Cell, status Status) error {
 	o.mu.Lock()
 	o.moveReceived = false
 	o.moveMsg = nil
 	o.tickId = tickId
 	o.sensor = sensor
-	o.mu.Unlock()
+	o.pingedAt = time.Now()
+	o.timed = false
 
 	pingMsg := NewPingMessage(tickId, sensor, o.position, status)
-	return o.conn.WriteJSON(pingMsg)
+	pingMsg.Discovered = o.exploration.Record(seen)
+	pingMsg.Stuck = o.stuck
+	pingMsg.Idle = o.idleTicks
+	if o.mapRequested {
+		pingMsg.Map = o.exploration.Cells()
+		o.mapRequested = false
+	}
+	if o.inventory != (Inventory{}) {
+		inventory := o.inventory
+		pingMsg.Inventory = &inventory
+	}
+	pingMsg.Team = o.teamInbox
+	o.teamInbox = nil
+	o.mu.Unlock()
+
+	return o.send(MsgPing, pingMsg)
+}
+
+// CountIdleTick is called once the tick is played, it returns the number of ticks in a row
+// the octapod sent no move. Octapods that joined during the tick are not counted.
+func (o *Octapod) CountIdleTick() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.moveReceived {
+		o.idleTicks = 0
+	} else if o.tickId != "" {
+		o.idleTicks++
+	}
+	return o.idleTicks
+}
+
+// IsAwaitingMove reports whether the octapod was pinged and has not moved yet this tick
+func (o *Octapod) IsAwaitingMove() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.tickId != "" && !o.moveReceived
+}
+
+func (o *Octapod) GetIdleTicks() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.idleTicks
+}
+
+// SetIdleTimeout drops the connection when the client sends nothing for the timeout,
+// heartbeats keep quiet clients alive
+func (o *Octapod) SetIdleTimeout(timeout time.Duration) {
+	o.writer.SetIdleTimeout(timeout)
+}
+
+// SetResponseWindow rejects the moves arriving later than the window after the ping, 0 never does
+func (o *Octapod) SetResponseWindow(window time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.responseWindow = window
+}
+
+// GetLatency returns how long the octapod took to answer its pings this round
+func (o *Octapod) GetLatency() Latency {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.latency
+}
+
+// Reset sends the octapod back to the position and forgets what it explored
+func (o *Octapod) Reset(position pkg.Vector) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.position = position
+	o.moveMsg = nil
+	o.exploration = NewExploration()
+	o.inventory = Inventory{}
+	o.stuck = 0
+	o.latency = Latency{}
+}
+
+// Respawn sends the octapod back to the position, keeping what it explored and picked up
+func (o *Octapod) Respawn(position pkg.Vector) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.position = position
+	o.moveMsg = nil
+	o.stuck = 0
 }
 
-func (o *Octapod) Disconnect() {
+// Stick holds the octapod in place for the ticks, its moves are refused as Stuck meanwhile
+func (o *Octapod) Stick(ticks int) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
-	err := o.conn.Close()
+	o.stuck = ticks
+}
+
+// SendStatus resends the last ping with a new status, the pending move is kept
+func (o *Octapod) SendStatus(status Status) error {
+	o.mu.Lock()
+	pingMsg := NewPingMessage(o.tickId, o.sensor, o.position, status)
+	o.mu.Unlock()
+
+	return o.send(MsgPing, pingMsg)
+}
+
+// StartRound tells the octapod about the new round
+func (o *Octapod) StartRound(info RoundInfo) error {
+	o.SetRound(info)
+	return o.send(MsgRoundStart, info)
+}
+
+// SetRound keeps the grid of the round the moves are checked against, StartRound sets it for the next rounds
+func (o *Octapod) SetRound(info RoundInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.grid = info.Grid()
+}
+
+// EndRound tells the octapod how it did in the round
+func (o *Octapod) EndRound(result RoundEndMessage) error {
+	return o.send(MsgRoundEnd, result)
+}
+
+// reject counts the error and tells the octapod about it
+func (o *Octapod) reject(code ErrorCode, tickId string, message string) {
+	o.mu.Lock()
+	o.errors[code]++
+	o.mu.Unlock()
+
+	err := o.send(MsgError, ErrorMessage{Code: code, Message: message, TickId: tickId})
 	if err != nil {
-		log.Printf("Error closing connection for %s: %v\n", o.id, err)
-		return
+		log.Printf("Error sending %s to %s: %v\n", code, o.id, err)
 	}
 }
 
-func (o *Octapod) TryUpdate() pkg.Vector {
+// GetErrorCounts returns the number of messages dropped since the octapod joined, by code
+func (o *Octapod) GetErrorCounts() map[ErrorCode]int {
 	o.mu.Lock()
 	defer o.mu.Unlock()
+	counts := make(map[ErrorCode]int, len(o.errors))
+	for code, count := range o.errors {
+		counts[code] = count
+	}
+	return counts
+}
+
+// SendKick tells the octapod why it is about to be disconnected
+func (o *Octapod) SendKick(reason string, banned bool) error {
+	return o.send(MsgKick, KickMessage{Reason: reason, Banned: banned})
+}
+
+// send writes the message in an envelope. Octapods on version 0 only understand pings,
+// they get them bare and every other message is dropped.
+func (o *Octapod) send(msgType MessageType, data any) error {
+	o.mu.Lock()
+	version := o.version
+	o.mu.Unlock()
+
+	if version == 0 {
+		if msgType != MsgPing {
+			return nil
+		}
+		return o.write(data)
+	}
+	return o.write(Envelope{Type: msgType, V: version, Data: data})
+}
+
+// PlanUpdate returns where the pending action would take the octapod if the rules
+// and the world allow it, the position is only changed by ApplyUpdate.
+// An octapod stuck in mud stays put, its move was refused when sent.
+func (o *Octapod) PlanUpdate(world World, rules ActionRules) pkg.Vector {
+	target, blocked := o.planUpdate(world, rules)
+	if blocked != nil {
+		o.reject(Blocked, blocked.TickId, "action blocked: "+string(blocked.GetAction())+" "+string(blocked.MoveDirection))
+	}
+	return target
+}
+
+// planUpdate returns the blocked action as well, so the octapod is told once the lock is released
+func (o *Octapod) planUpdate(world World, rules ActionRules) (pkg.Vector, *MoveMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stuck > 0 {
+		o.stuck--
+		o.moveMsg = nil
+		return o.position, nil
+	}
 
 	if o.moveMsg == nil {
-		return o.position
+		return o.position, nil
 	}
 
-	moveDirection := o.moveMsg.ToVector()
+	target, ok := rules.resolve(o.moveMsg, o.position, world)
+	if !ok {
+		log.Printf("Action blocked for %s: %s %s\n", o.id, o.moveMsg.GetAction(), o.moveMsg.MoveDirection)
+		blocked := o.moveMsg
+		o.moveMsg = nil
+		return o.position, blocked
+	}
+	return target, nil
+}
+
+// Block drops the pending action after it was planned, like an action into a wall
+func (o *Octapod) Block(reason string) {
+	o.mu.Lock()
+	blocked := o.moveMsg
+	o.moveMsg = nil
+	o.mu.Unlock()
+
+	if blocked != nil {
+		log.Printf("Action blocked for %s: %s\n", o.id, reason)
+		o.reject(Blocked, blocked.TickId, "action blocked: "+reason)
+	}
+}
+
+// ApplyUpdate moves the octapod to the resolved target and consumes the pending action
+func (o *Octapod) ApplyUpdate(target pkg.Vector) pkg.Vector {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 
-	if o.sensor.IsBlocked(moveDirection) {
-		log.Printf("Move blocked for %s: %s\n", o.id, o.moveMsg.MoveDirection)
+	if o.moveMsg == nil {
 		return o.position
 	}
 
-	log.Printf("Applying moveMsg %s to %s\n", o.moveMsg.MoveDirection, o.id)
-	o.position = o.position.Add(moveDirection)
+	if o.moveMsg.GetAction() == Scan {
+		o.scanRequested = true
+	}
+
+	log.Printf("Applying %s %s to %s\n", o.moveMsg.GetAction(), o.moveMsg.MoveDirection, o.id)
+	o.moveMsg = nil
+	o.position = target
 	return o.position
 }
 
+// Collect adds the picked up tile to the inventory
+func (o *Octapod) Collect(kind pkg.TileKind) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inventory.collect(kind)
+}
+
+func (o *Octapod) GetInventory() Inventory {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.inventory
+}
+
+// ConsumeScan reports whether the octapod scanned this tick, once
+func (o *Octapod) ConsumeScan() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	scanned := o.scanRequested
+	o.scanRequested = false
+	return scanned
+}
+
 func (o *Octapod) readLoop() {
 	for {
-		_, data, err := o.conn.ReadMessage()
+		data, err := o.transport.Read()
 		if err != nil {
 			log.Println("read error:", err)
 			if o.onDisconnect != nil {
@@ -91,37 +350,163 @@ func (o *Octapod) readLoop() {
 			return
 		}
 
-		if o.moveReceived {
-			log.Printf("Move already received for %s\n", o.id)
+		var message inbound
+		err = o.codec.Decode(data, &message)
+		if err != nil {
+			log.Printf("Error decoding message for %s: %v\n", o.id, err)
+			o.reject(Malformed, "", "message cannot be decoded")
 			continue
 		}
-		o.moveReceived = true
 
-		var moveMsg MoveMessage
-		err = json.Unmarshal(data, &moveMsg)
-		if err != nil {
-			log.Printf("Error unmarshalling moveMsg for %s: %v\n", o.id, err)
-			continue
+		switch message.Type {
+		case "":
+			// Bare move from an octapod on version 0
+			var moveMsg MoveMessage
+			if err := o.codec.Decode(data, &moveMsg); err != nil {
+				log.Printf("Error decoding moveMsg for %s: %v\n", o.id, err)
+				o.reject(Malformed, "", "move cannot be decoded")
+				continue
+			}
+			o.handleMove(&moveMsg)
+		case MsgMove:
+			if message.Data == nil {
+				o.reject(Malformed, "", "move has no data")
+				continue
+			}
+			o.handleMove(message.Data)
+		case MsgHello:
+			o.handleHello(message.V)
+		default:
+			log.Printf("Unknown message type from %s: %s\n", o.id, message.Type)
+			o.reject(UnknownType, "", "unknown message type "+string(message.Type))
 		}
+	}
+}
 
-		if o.tickId != moveMsg.TickId {
-			log.Printf("Tick id mismatch for %s: %s != %s\n", o.id, o.tickId, moveMsg.TickId)
-			continue
+// handleHello agrees on the highest version both sides speak and welcomes the octapod
+func (o *Octapod) handleHello(version int) {
+	if version < 1 {
+		o.reject(UnsupportedVersion, "", "protocol version must be at least 1")
+		return
+	}
+
+	o.mu.Lock()
+	o.version = min(version, ProtocolVersion)
+	welcome := WelcomeMessage{
+		Id:      o.id,
+		Team:    o.team,
+		Version: o.version,
+	}
+	o.mu.Unlock()
+
+	if o.roundInfo != nil {
+		welcome.Round = o.roundInfo()
+	}
+	log.Printf("Octapod %s speaks protocol version %d\n", o.id, welcome.Version)
+	if err := o.send(MsgWelcome, welcome); err != nil {
+		log.Printf("Error welcoming %s: %v\n", o.id, err)
+	}
+}
+
+// handleMove keeps the move for the tick and acknowledges it, or tells the octapod why it was dropped
+func (o *Octapod) handleMove(moveMsg *MoveMessage) {
+	code, reason := o.acceptMove(moveMsg)
+	if code == "" {
+		err := o.send(MsgAck, AckMessage{TickId: moveMsg.TickId})
+		if err != nil {
+			log.Printf("Error acknowledging move for %s: %v\n", o.id, err)
 		}
+	} else {
+		log.Printf("Move dropped for %s: %s\n", o.id, reason)
+		o.reject(code, moveMsg.TickId, reason)
+		// A stuck octapod answered the tick all the same, it is neither idle nor holding up the fast mode
+		if code != Stuck {
+			return
+		}
+	}
+	if o.onMove != nil {
+		o.onMove(o.id)
+	}
+}
+
+// acceptMove keeps the move as the action for the tick, the error code is empty when accepted
+func (o *Octapod) acceptMove(moveMsg *MoveMessage) (ErrorCode, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.moveReceived {
+		return AlreadyMoved, "a move was already accepted for tick " + o.tickId
+	}
+	if o.tickId != moveMsg.TickId {
+		return StaleTick, "tick id " + moveMsg.TickId + " is not the last ping " + o.tickId
+	}
+
+	latency := time.Since(o.pingedAt)
+	if !o.timed {
+		o.latency.record(latency)
+		o.timed = true
+	}
+	if o.responseWindow > 0 && latency > o.responseWindow {
+		return Late, "move took " + latency.Round(time.Millisecond).String() + ", the response window is " + o.responseWindow.String()
+	}
+	if o.stuck > 0 {
+		o.moveReceived = true
+		return Stuck, "stuck in mud for " + strconv.Itoa(o.stuck) + " more ticks"
+	}
+	if !moveMsg.IsValid(o.grid) {
+		return InvalidMove, "cannot " + string(moveMsg.GetAction()) + " " + string(moveMsg.MoveDirection) + " here"
+	}
 
-		o.mu.Lock()
-		log.Printf("Move received from %s: %s\n", o.id, moveMsg.MoveDirection)
-		o.moveMsg = &moveMsg
-		o.mu.Unlock()
+	o.moveReceived = true
+	log.Printf("Move received from %s: %s %s\n", o.id, moveMsg.GetAction(), moveMsg.MoveDirection)
+	o.moveMsg = moveMsg
+	if moveMsg.RequestMap {
+		o.mapRequested = true
+	}
+	if moveMsg.TeamMessage != "" || len(moveMsg.TeamCells) > 0 {
+		o.teamOutbox = &TeamMessage{
+			From:    o.id,
+			Message: moveMsg.TeamMessage,
+			Cells:   moveMsg.TeamCells,
+		}
 	}
+	return "", ""
 }
 
 func (o *Octapod) GetId() string {
 	return o.id
 }
 
+// GetTeam returns the team the octapod joined, empty when playing alone
+func (o *Octapod) GetTeam() string {
+	return o.team
+}
+
+// ConsumeTeamMessage returns the message sent to the team this tick, once
+func (o *Octapod) ConsumeTeamMessage() *TeamMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	message := o.teamOutbox
+	o.teamOutbox = nil
+	return message
+}
+
+// Deliver queues teammate messages for the next ping
+func (o *Octapod) Deliver(messages []TeamMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.teamInbox = append(o.teamInbox, messages...)
+}
+
 func (o *Octapod) GetPosition() pkg.Vector {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	return o.position.Copy()
 }
+
+// GetExploredCount returns the number of open cells the octapod discovered this round
+func (o *Octapod) GetExploredCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.exploration.OpenCount()
+}
@@ -15,6 +15,7 @@ const (
 	Solving   Status = "Solve"
 	Solved    Status = "Solved"
 	Ended     Status = "Ended"
+	Paused    Status = "Paused"
 )
 
 type PingMessage struct {
@@ -22,6 +23,23 @@ type PingMessage struct {
 	Sensor   *pkg.Sensor `json:"sensor"`
 	Position pkg.Vector  `json:"position"`
 	Status   Status      `json:"status"`
+
+	// Discovered holds the cells seen for the first time this tick, or changed since last seen
+	Discovered []pkg.Cell `json:"discovered,omitempty"`
+	// Map holds every cell discovered this round, only sent when requested
+	Map []pkg.Cell `json:"map,omitempty"`
+
+	// Idle is the number of ticks since the octapod last moved, idle octapods can be evicted
+	Idle int `json:"idle,omitempty"`
+
+	// Stuck is the number of ticks the octapod is still held in mud
+	Stuck int `json:"stuck,omitempty"`
+
+	// Inventory holds what the octapod picked up this round, omitted while empty
+	Inventory *Inventory `json:"inventory,omitempty"`
+
+	// Team holds the messages sent by teammates during the last tick
+	Team []TeamMessage `json:"team,omitempty"`
 }
 
 func NewPingMessage(tickId string, sensor *pkg.Sensor, position pkg.Vector, status Status) *PingMessage {
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+// ProtocolVersion is the latest version of the wire protocol.
+// Octapods that never say hello are on version 0, they only get bare pings and send bare moves.
+const ProtocolVersion = 1
+
+type MessageType string
+
+const (
+	// MsgHello is sent by the octapod to switch to the versioned protocol
+	MsgHello MessageType = "hello"
+	// MsgWelcome answers the hello with the octapod and round details
+	MsgWelcome MessageType = "welcome"
+	MsgPing    MessageType = "ping"
+	MsgMove    MessageType = "move"
+	// MsgAck confirms the move was accepted for the tick
+	MsgAck        MessageType = "ack"
+	MsgRoundStart MessageType = "roundStart"
+	MsgRoundEnd   MessageType = "roundEnd"
+	MsgError      MessageType = "error"
+	MsgKick       MessageType = "kick"
+	// MsgState is the whole lobby after a tick, only sent to spectators
+	MsgState MessageType = "state"
+)
+
+// Envelope wraps every message of the versioned protocol
+type Envelope struct {
+	Type MessageType `json:"type"`
+	V    int         `json:"v"`
+	Data any         `json:"data,omitempty"`
+}
+
+// inbound is an envelope received from an octapod, a bare move has no type.
+// Moves are the only messages with data, so it is decoded in the same pass whatever the codec.
+type inbound struct {
+	Type MessageType  `json:"type"`
+	V    int          `json:"v"`
+	Data *MoveMessage `json:"data,omitempty"`
+}
+
+// RoundInfo describes the maze and the rules of the round in progress
+type RoundInfo struct {
+	Round               int          `json:"round"`
+	Width               int          `json:"width"`
+	Height              int          `json:"height"`
+	Floors              int          `json:"floors"`
+	Topology            pkg.Topology `json:"topology"`
+	TickInterval        int          `json:"tickInterval"`
+	MaxExplorationSteps int          `json:"maxExplorationSteps"`
+	MaxSolvingSteps     int          `json:"maxSolvingSteps"`
+	Rules               ActionRules  `json:"rules"`
+}
+
+// Grid returns the grid the moves of the round are checked against
+func (r RoundInfo) Grid() pkg.Grid {
+	return pkg.Grid{Topology: r.Topology, Width: r.Width, Height: r.Height, Floors: r.Floors}
+}
+
+type WelcomeMessage struct {
+	Id      string    `json:"id"`
+	Team    string    `json:"team,omitempty"`
+	Version int       `json:"version"`
+	Round   RoundInfo `json:"round"`
+}
+
+// RoundEndMessage is the result of the round for the octapod
+type RoundEndMessage struct {
+	Round    int     `json:"round"`
+	Outcome  string  `json:"outcome"`
+	Solved   bool    `json:"solved"`
+	Score    int     `json:"score"`
+	Coverage float64 `json:"coverage"`
+}
+
+// AckMessage confirms the move for the tick, it can still be blocked when the tick is played
+type AckMessage struct {
+	TickId string `json:"tickId"`
+}
+
+type ErrorCode string
+
+const (
+	UnsupportedVersion ErrorCode = "unsupportedVersion"
+	UnknownType        ErrorCode = "unknownType"
+	// JoinRefused is sent before closing a connection that could not join, with the reason
+	JoinRefused ErrorCode = "joinRefused"
+	// Malformed messages cannot be decoded
+	Malformed ErrorCode = "malformed"
+	// StaleTick moves answer a ping other than the last one
+	StaleTick ErrorCode = "staleTick"
+	// AlreadyMoved moves come after a move was accepted for the tick
+	AlreadyMoved ErrorCode = "alreadyMoved"
+	// Late moves arrived after the response window of the tick
+	Late ErrorCode = "late"
+	// InvalidMove moves have an unknown action or a direction the grid doesn't have
+	InvalidMove ErrorCode = "invalidMove"
+	// Blocked moves were accepted but ran into a wall or another octapod, or cost too many action points
+	Blocked ErrorCode = "blocked"
+	// Stuck moves arrived while the octapod is held in mud, the tick is spent in place
+	Stuck ErrorCode = "stuck"
+	// Spectating is sent to spectators for every message, they cannot move
+	Spectating ErrorCode = "spectating"
+)
+
+// ErrorMessage tells the octapod why its message was dropped.
+// Every error but Blocked leaves the octapod free to send another move for the tick.
+type ErrorMessage struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	TickId  string    `json:"tickId,omitempty"`
+}
+
+type KickMessage struct {
+	Reason string `json:"reason"`
+	Banned bool   `json:"banned,omitempty"`
+}
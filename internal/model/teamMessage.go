@@ -0,0 +1,22 @@
+package model
+
+import (
+	"encoding/json"
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+// TeamMessage is relayed by the server from an octapod to its teammates on the next ping
+type TeamMessage struct {
+	From    string     `json:"from"`
+	Message string     `json:"message,omitempty"`
+	Cells   []pkg.Cell `json:"cells,omitempty"`
+}
+
+// Size is the encoded size in bytes, checked against the per-tick team message limit
+func (m *TeamMessage) Size() int {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+	"time"
+)
+
+// Transport carries the encoded messages of a single octapod connection.
+// Read is only called by the octapod read loop, Write, SetWriteDeadline and Heartbeat by its writer.
+type Transport interface {
+	Read() ([]byte, error)
+	Write(data []byte) error
+	SetWriteDeadline(deadline time.Time) error
+	// SetIdleTimeout fails Read when nothing, heartbeat answers included, is received for the timeout.
+	// A zero timeout waits forever.
+	SetIdleTimeout(timeout time.Duration)
+	// Heartbeat asks the client for a sign of life, transports without one do nothing
+	Heartbeat() error
+	Close() error
+}
+
+type websocketTransport struct {
+	conn        *websocket.Conn
+	frameType   int
+	idleTimeout atomic.Int64
+}
+
+// NewWebsocketTransport sends every message in its own frame, binary frames for binary codecs.
+// Heartbeats are websocket pings, their pongs keep the connection alive.
+func NewWebsocketTransport(conn *websocket.Conn, binary bool) Transport {
+	frameType := websocket.TextMessage
+	if binary {
+		frameType = websocket.BinaryMessage
+	}
+	t := &websocketTransport{conn: conn, frameType: frameType}
+	conn.SetPongHandler(func(string) error {
+		return t.extendReadDeadline()
+	})
+	return t
+}
+
+func (t *websocketTransport) Read() ([]byte, error) {
+	_, data, err := t.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return data, t.extendReadDeadline()
+}
+
+func (t *websocketTransport) SetIdleTimeout(timeout time.Duration) {
+	t.idleTimeout.Store(int64(timeout))
+	_ = t.extendReadDeadline()
+}
+
+func (t *websocketTransport) extendReadDeadline() error {
+	timeout := time.Duration(t.idleTimeout.Load())
+	if timeout == 0 {
+		return t.conn.SetReadDeadline(time.Time{})
+	}
+	return t.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func (t *websocketTransport) Heartbeat() error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+func (t *websocketTransport) Write(data []byte) error {
+	return t.conn.WriteMessage(t.frameType, data)
+}
+
+func (t *websocketTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+func (t *websocketTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// outboxSize is the number of messages a client can fall behind before it is dropped
+	outboxSize = 32
+	// writeTimeout bounds a single write, a client that doesn't read is dropped
+	writeTimeout = 5 * time.Second
+)
+
+var (
+	ErrSlowConsumer = errors.New("client is not reading its messages fast enough")
+	ErrDisconnected = errors.New("client is disconnected")
+)
+
+// Writer is the only writer of a transport, octapods and spectators alike. Messages are queued without
+// blocking and written by its own goroutine, so the tick never waits on a slow client.
+type Writer struct {
+	name        string
+	transport   Transport
+	outbox      chan []byte
+	closing     chan struct{}
+	closeOnce   sync.Once
+	idleTimeout atomic.Int64
+}
+
+// NewWriter writes to the transport once Run is called, name is only used in the logs
+func NewWriter(name string, transport Transport) *Writer {
+	return &Writer{
+		name:      name,
+		transport: transport,
+		outbox:    make(chan []byte, outboxSize),
+		closing:   make(chan struct{}),
+	}
+}
+
+// Write queues the message without blocking.
+// It fails when the outbox is full, the caller is expected to close the writer.
+func (w *Writer) Write(data []byte) error {
+	select {
+	case <-w.closing:
+		return ErrDisconnected
+	default:
+	}
+
+	select {
+	case w.outbox <- data:
+		return nil
+	default:
+		log.Printf("Outbox full for %s, dropping it\n", w.name)
+		return ErrSlowConsumer
+	}
+}
+
+// Queued returns the number of messages waiting to be written
+func (w *Writer) Queued() int {
+	return len(w.outbox)
+}
+
+// SetIdleTimeout drops the connection when the client sends nothing for the timeout,
+// heartbeats keep quiet clients alive
+func (w *Writer) SetIdleTimeout(timeout time.Duration) {
+	w.idleTimeout.Store(int64(timeout))
+	w.transport.SetIdleTimeout(timeout)
+}
+
+// Close closes the transport once the queued messages are flushed.
+// The whole flush shares a single write timeout, it never blocks the caller.
+func (w *Writer) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+	})
+}
+
+// Run writes the queued messages and the heartbeats until closed or a write fails
+func (w *Writer) Run() {
+	defer w.closeTransport()
+
+	heartbeat := time.NewTimer(w.heartbeatInterval())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case data := <-w.outbox:
+			if !w.writeNow(data, time.Now().Add(writeTimeout)) {
+				return
+			}
+		case <-heartbeat.C:
+			if w.idleTimeout.Load() > 0 {
+				if err := w.transport.Heartbeat(); err != nil {
+					log.Printf("Heartbeat error for %s: %v\n", w.name, err)
+					return
+				}
+			}
+			heartbeat.Reset(w.heartbeatInterval())
+		case <-w.closing:
+			w.flush()
+			return
+		}
+	}
+}
+
+// heartbeatInterval leaves the client a few heartbeats to answer before the idle timeout
+func (w *Writer) heartbeatInterval() time.Duration {
+	return max(time.Duration(w.idleTimeout.Load())/3, time.Second)
+}
+
+// flush writes what is left in the outbox, a full outbox gets the time of a single write
+func (w *Writer) flush() {
+	deadline := time.Now().Add(writeTimeout)
+	for {
+		select {
+		case data := <-w.outbox:
+			if !w.writeNow(data, deadline) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (w *Writer) writeNow(data []byte, deadline time.Time) bool {
+	err := w.transport.SetWriteDeadline(deadline)
+	if err == nil {
+		err = w.transport.Write(data)
+	}
+	if err != nil {
+		log.Printf("Write error for %s: %v\n", w.name, err)
+		return false
+	}
+	return true
+}
+
+// closeTransport ends the read loop as well, which reports the disconnect
+func (w *Writer) closeTransport() {
+	err := w.transport.Close()
+	if err != nil {
+		log.Printf("Error closing connection for %s: %v\n", w.name, err)
+	}
+}
+
+// write encodes the message for the writer, see Writer.Write
+func (o *Octapod) write(message any) error {
+	data, err := o.codec.Encode(message)
+	if err != nil {
+		return err
+	}
+	return o.writer.Write(data)
+}
+
+// GetQueued returns the number of messages waiting to be written
+func (o *Octapod) GetQueued() int {
+	return o.writer.Queued()
+}
+
+// Disconnect closes the connection once the writer flushed the queued messages, like a kick
+func (o *Octapod) Disconnect() {
+	o.writer.Close()
+}
@@ -1,102 +1,326 @@
 package server
 
 import (
+	"crypto/subtle"
 	"gbccsclub/octopod-challenge/internal/web"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"strings"
 )
 
 type AdminHandler struct {
-	config *Config
+	config   *Config
+	store    *ConfigStore
+	sessions *sessionManager
 }
 
-func NewAdminHandler(c *Config) *AdminHandler {
+func NewAdminHandler(c *Config, store *ConfigStore) *AdminHandler {
 	return &AdminHandler{
-		config: c,
+		config:   c,
+		store:    store,
+		sessions: newSessionManager(),
 	}
 }
 
-func (ah *AdminHandler) HandleGetConfig(c *gin.Context, templ *web.Templates) {
-	ah.config.mu.Lock()
-	defer ah.config.mu.Unlock()
+// RequireAuth guards every /admin route.
+// Accepts "Bearer <ADMIN_API_TOKEN>", basic auth with the admin password for scripts,
+// or a session cookie from the login page. Session requests must carry the CSRF token
+// in the X-CSRF-Token header unless they are read only.
+func (ah *AdminHandler) RequireAuth(c *gin.Context) {
+	client := c.ClientIP()
+	if ah.sessions.IsLocked(client) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts"})
+		return
+	}
 
-	props := map[string]interface{}{
-		"TickInterval":        ah.config.TickInterval,
-		"MazeSize":            ah.config.MazeSize,
-		"DiscordChannelId":    ah.config.DiscordChannelId,
-		"MaxExplorationSteps": ah.config.MaxExplorationSteps,
-		"MaxSolvingSteps":     ah.config.MaxSolvingSteps,
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		apiToken := os.Getenv("ADMIN_API_TOKEN")
+		if apiToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+			ah.sessions.RecordFailure(client)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		c.Next()
+		return
 	}
 
-	templ.Render(c.Writer, "admin", props)
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		if !checkPassword(password) {
+			ah.sessions.RecordFailure(client)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+			return
+		}
+		c.Next()
+		return
+	}
+
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		if sessionId, ok := ah.sessions.Verify(cookie); ok {
+			method := c.Request.Method
+			isReadOnly := method == http.MethodGet || method == http.MethodHead
+			if !isReadOnly && !ah.sessions.CheckCsrf(sessionId, c.GetHeader("X-CSRF-Token")) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+				return
+			}
+			c.Set("sessionId", sessionId)
+			c.Next()
+			return
+		}
+	}
+
+	// Send people back to the login page, scripts get a plain 401
+	if isHtmx(c) {
+		c.Header("HX-Redirect", "/admin/login")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	if c.Request.Method == http.MethodGet && c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEHTML {
+		c.Redirect(http.StatusSeeOther, "/admin/login")
+		c.Abort()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 }
 
-func (ah *AdminHandler) HandleUpdateConfig(c *gin.Context, templ *web.Templates, lobby *Lobby) bool {
-	password := c.PostForm("password")
+func checkPassword(password string) bool {
 	hashedPassword := os.Getenv("HASHED_PASSWORD")
-	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
-		templ.Render(c.Writer, "error_message", map[string]interface{}{
-			"Message": "Invalid password",
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+func (ah *AdminHandler) HandleGetLogin(c *gin.Context, templ *web.Templates) {
+	templ.Render(c.Writer, "login", nil)
+}
+
+func (ah *AdminHandler) HandleLogin(c *gin.Context, templ *web.Templates) {
+	client := c.ClientIP()
+	if ah.sessions.IsLocked(client) {
+		c.Status(http.StatusTooManyRequests)
+		templ.Render(c.Writer, "login", map[string]interface{}{
+			"Message": "Too many failed attempts, try again later",
 		})
-		return false
+		return
 	}
 
-	tickInterval, err := strconv.Atoi(c.PostForm("tick_interval"))
-	if err != nil {
-		templ.Render(c.Writer, "error_message", map[string]interface{}{
-			"Message": "Invalid tick interval",
+	if !checkPassword(c.PostForm("password")) {
+		ah.sessions.RecordFailure(client)
+		c.Status(http.StatusUnauthorized)
+		templ.Render(c.Writer, "login", map[string]interface{}{
+			"Message": "Invalid password",
 		})
-		return false
+		return
 	}
+	ah.sessions.RecordSuccess(client)
 
-	maxExplorationSteps, err := strconv.Atoi(c.PostForm("max_exploration_steps"))
-	if err != nil {
-		templ.Render(c.Writer, "error_message", map[string]interface{}{
-			"Message": "Invalid max exploration steps",
-		})
+	cookie, expiry := ah.sessions.Issue()
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    cookie,
+		Path:     "/admin",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// HandleLogout closes the session on the server, a copy of the cookie can't be used afterwards
+func (ah *AdminHandler) HandleLogout(c *gin.Context) {
+	if sessionId := c.GetString("sessionId"); sessionId != "" {
+		ah.sessions.Close(sessionId)
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/admin",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Header("HX-Redirect", "/admin/login")
+	c.Status(http.StatusOK)
+}
+
+func isHtmx(c *gin.Context) bool {
+	return c.GetHeader("HX-Request") != ""
+}
+
+// respond renders the template for htmx requests from the panel, JSON otherwise
+func respond(c *gin.Context, templ *web.Templates, name string, data interface{}) {
+	if !isHtmx(c) {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	templ.Render(c.Writer, name, data)
+}
+
+func respondMessage(c *gin.Context, templ *web.Templates, message string) {
+	if !isHtmx(c) {
+		c.JSON(http.StatusOK, gin.H{"message": message})
+		return
+	}
+	templ.Render(c.Writer, "success_message", gin.H{"Message": message})
+}
+
+func respondError(c *gin.Context, templ *web.Templates, status int, message string) {
+	if !isHtmx(c) {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	// htmx does not swap error responses by default, so the toast is sent with 200
+	templ.Render(c.Writer, "error_message", gin.H{"Message": message})
+}
+
+func (ah *AdminHandler) HandleGetConfig(c *gin.Context, templ *web.Templates) {
+	config := ah.config.Export()
+
+	props := map[string]interface{}{
+		"TickInterval":        config.TickInterval,
+		"FastMode":            config.FastMode,
+		"MazeSize":            config.MazeSize,
+		"Topology":            config.Topology,
+		"Floors":              config.Floors,
+		"StairsPerFloor":      config.StairsPerFloor,
+		"DoorCount":           config.DoorCount,
+		"DoorPeriod":          config.DoorPeriod,
+		"WallShiftInterval":   config.WallShiftInterval,
+		"WallShiftCount":      config.WallShiftCount,
+		"CoinCount":           config.CoinCount,
+		"KeyCount":            config.KeyCount,
+		"LockCount":           config.LockCount,
+		"OneWayCount":         config.OneWayCount,
+		"TeleporterCount":     config.TeleporterCount,
+		"MudCount":            config.MudCount,
+		"MudCost":             config.MudCost,
+		"IceCount":            config.IceCount,
+		"TrapCount":           config.TrapCount,
+		"SolveRequiresKey":    config.SolveRequiresKey,
+		"SolveMinCoins":       config.SolveMinCoins,
+		"CoinScore":           config.CoinScore,
+		"SolveScore":          config.SolveScore,
+		"PathScore":           config.PathScore,
+		"DiscordChannelId":    config.DiscordChannelId,
+		"MaxExplorationSteps": config.MaxExplorationSteps,
+		"MaxSolvingSteps":     config.MaxSolvingSteps,
+		"ActionPoints":        config.ActionPoints,
+		"DashCost":            config.DashCost,
+		"CollisionMode":       config.CollisionMode,
+		"TeamMode":            config.TeamMode,
+		"TeamMessageLimit":    config.TeamMessageLimit,
+		"IdleTimeout":         config.IdleTimeout,
+		"AfkTicks":            config.AfkTicks,
+		"ResponseWindow":      config.ResponseWindow,
+		"SensorRangeFinder":   config.SensorRangeFinder,
+		"SensorCompass":       config.SensorCompass,
+		"SensorSignal":        config.SensorSignal,
+		"SensorProximity":     config.SensorProximity,
+		"ProximityRadius":     config.ProximityRadius,
+		"CsrfToken":           ah.sessions.CsrfToken(c.GetString("sessionId")),
+	}
+
+	templ.Render(c.Writer, "admin", props)
+}
+
+// ==================== API ====================
+
+func (ah *AdminHandler) HandleApiGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, ah.config.Export())
+}
+
+func (ah *AdminHandler) HandleUpdateConfig(c *gin.Context, templ *web.Templates, lobby *Lobby) bool {
+	var update ConfigUpdate
+	if err := c.ShouldBind(&update); err != nil {
+		respondError(c, templ, http.StatusBadRequest, "Invalid configuration: "+err.Error())
 		return false
 	}
 
-	maxSolvingSteps, err := strconv.Atoi(c.PostForm("max_solving_steps"))
+	// The panel submits every field, only the ones edited are kept over the config file
+	before := ah.config.Export()
+	changes, err := lobby.ApplyConfig(update)
 	if err != nil {
-		templ.Render(c.Writer, "error_message", map[string]interface{}{
-			"Message": "Invalid max solving steps",
-		})
+		respondError(c, templ, http.StatusBadRequest, err.Error())
 		return false
 	}
 
-	mazeSize, err := strconv.Atoi(c.PostForm("maze_size"))
-	if err != nil {
-		templ.Render(c.Writer, "error_message", map[string]interface{}{
-			"Message": "Invalid maze size",
-		})
+	if err = ah.store.Save(update.Changed(before)); err != nil {
+		log.Printf("Failed to save config: %v\n", err)
+		respondError(c, templ, http.StatusInternalServerError, "Configuration applied but could not be saved")
 		return false
 	}
 
-	discordBotToken := c.PostForm("discord_bot_token")
-	if discordBotToken == "" {
-		discordBotToken = os.Getenv("DISCORD_BOT_TOKEN")
+	switch {
+	case changes.Round:
+		respondMessage(c, templ, "Configuration updated, a new round was started")
+	case changes.Any():
+		respondMessage(c, templ, "Configuration updated and applied live")
+	default:
+		respondMessage(c, templ, "Configuration updated successfully")
 	}
+	return true
+}
 
-	discordChannelId := c.PostForm("discord_channel_id")
+func (ah *AdminHandler) HandleRestart(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	lobby.RequestRestart()
+	respondMessage(c, templ, "Restart requested")
+}
 
-	ah.config.mu.Lock()
-	defer ah.config.mu.Unlock()
+func (ah *AdminHandler) HandlePause(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	lobby.Pause()
+	respondMessage(c, templ, "Lobby paused")
+}
 
-	ah.config.TickInterval = tickInterval
-	ah.config.MazeSize = mazeSize
-	ah.config.DiscordBotToken = discordBotToken
-	ah.config.DiscordChannelId = discordChannelId
-	ah.config.MaxExplorationSteps = maxExplorationSteps
-	ah.config.MaxSolvingSteps = maxSolvingSteps
+func (ah *AdminHandler) HandleResume(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	lobby.Resume()
+	respondMessage(c, templ, "Lobby resumed")
+}
 
-	lobby.RequestRestart()
+func (ah *AdminHandler) HandleStep(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	if err := lobby.Step(); err != nil {
+		respondError(c, templ, http.StatusConflict, err.Error())
+		return
+	}
+	respondMessage(c, templ, "Advanced one tick")
+}
 
-	templ.Render(c.Writer, "success_message", map[string]interface{}{
-		"Message": "Configuration updated successfully",
-	})
+func (ah *AdminHandler) HandleGetStatus(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	respond(c, templ, "lobby_status", lobby.GetStatus())
+}
 
-	return true
+func (ah *AdminHandler) HandleListOctapods(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	respond(c, templ, "octapod_list", lobby.OctapodHandler.ListOctapods())
+}
+
+func (ah *AdminHandler) HandleKick(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	id := c.Param("id")
+	if !lobby.OctapodHandler.Kick(id) {
+		respondError(c, templ, http.StatusNotFound, "Octapod "+id+" is not connected")
+		return
+	}
+	respondMessage(c, templ, "Kicked "+id)
+}
+
+func (ah *AdminHandler) HandleListBans(c *gin.Context, lobby *Lobby) {
+	c.JSON(http.StatusOK, lobby.OctapodHandler.GetBanned())
+}
+
+func (ah *AdminHandler) HandleBan(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	id := c.Param("id")
+	lobby.OctapodHandler.Ban(id)
+	respondMessage(c, templ, "Banned "+id)
+}
+
+func (ah *AdminHandler) HandleUnban(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	id := c.Param("id")
+	if !lobby.OctapodHandler.Unban(id) {
+		respondError(c, templ, http.StatusNotFound, "Octapod "+id+" is not banned")
+		return
+	}
+	respondMessage(c, templ, "Unbanned "+id)
+}
+
+func (ah *AdminHandler) HandleListRounds(c *gin.Context, templ *web.Templates, lobby *Lobby) {
+	respond(c, templ, "round_list", lobby.GetRounds())
 }
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "admin_session"
+	sessionTtl        = 12 * time.Hour
+
+	maxLoginFailures = 5
+	loginLockout     = 15 * time.Minute
+)
+
+// sessionManager issues signed admin session cookies and rate limits logins.
+// The cookie holds "<session id>|<expiry>" signed with the secret, the session must also still be open on the server
+// so logging out ends it for every copy of the cookie. Sessions live in memory, a restart logs every admin out.
+type sessionManager struct {
+	secret []byte
+
+	mu sync.Mutex
+	// sessions maps the open session ids to their expiry
+	sessions map[string]time.Time
+	failures map[string]*loginFailures
+	// pruned is when the expired sessions and failures were last forgotten
+	pruned time.Time
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newSessionManager() *sessionManager {
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		log.Println("SESSION_SECRET not set, using a random secret")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+	}
+
+	return &sessionManager{
+		secret:   secret,
+		sessions: make(map[string]time.Time),
+		failures: make(map[string]*loginFailures),
+		pruned:   time.Now(),
+	}
+}
+
+func (sm *sessionManager) sign(value string) string {
+	mac := hmac.New(sha256.New, sm.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue creates a new session cookie value and its expiry
+func (sm *sessionManager) Issue() (string, time.Time) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	expiry := time.Now().Add(sessionTtl)
+	sessionId := hex.EncodeToString(id)
+
+	sm.mu.Lock()
+	sm.prune()
+	sm.sessions[sessionId] = expiry
+	sm.mu.Unlock()
+
+	value := sessionId + "|" + strconv.FormatInt(expiry.Unix(), 10)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return payload + "." + sm.sign(value), expiry
+}
+
+// Verify returns the session id of a valid, unexpired cookie whose session was not closed
+func (sm *sessionManager) Verify(cookie string) (string, bool) {
+	payload, signature, ok := strings.Cut(cookie, ".")
+	if !ok {
+		return "", false
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	value := string(raw)
+
+	if !hmac.Equal([]byte(signature), []byte(sm.sign(value))) {
+		return "", false
+	}
+
+	id, expiryStr, ok := strings.Cut(value, "|")
+	if !ok {
+		return "", false
+	}
+
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", false
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if _, ok := sm.sessions[id]; !ok {
+		return "", false
+	}
+	return id, true
+}
+
+// Close ends the session, its cookie is refused from then on
+func (sm *sessionManager) Close(sessionId string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.sessions, sessionId)
+}
+
+// CsrfToken derives the CSRF token bound to a session
+func (sm *sessionManager) CsrfToken(sessionId string) string {
+	return sm.sign("csrf|" + sessionId)
+}
+
+func (sm *sessionManager) CheckCsrf(sessionId string, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(sm.CsrfToken(sessionId))) == 1
+}
+
+// IsLocked reports whether the client is locked out after too many failed logins
+func (sm *sessionManager) IsLocked(client string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	failures, ok := sm.failures[client]
+	return ok && time.Now().Before(failures.lockedUntil)
+}
+
+func (sm *sessionManager) RecordFailure(client string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.prune()
+
+	failures, ok := sm.failures[client]
+	if !ok {
+		failures = &loginFailures{}
+		sm.failures[client] = failures
+	}
+
+	failures.count++
+	failures.lastFailure = time.Now()
+	if failures.count >= maxLoginFailures {
+		log.Printf("Too many failed admin logins from %s, locking out\n", client)
+		failures.count = 0
+		failures.lockedUntil = time.Now().Add(loginLockout)
+	}
+}
+
+func (sm *sessionManager) RecordSuccess(client string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.failures, client)
+}
+
+// prune forgets the expired sessions, and the failures that no longer count toward a lockout.
+// It runs at most once a minute, the caller must hold the lock.
+func (sm *sessionManager) prune() {
+	now := time.Now()
+	if now.Sub(sm.pruned) < time.Minute {
+		return
+	}
+	sm.pruned = now
+
+	for id, expiry := range sm.sessions {
+		if now.After(expiry) {
+			delete(sm.sessions, id)
+		}
+	}
+	for client, failures := range sm.failures {
+		if now.After(failures.lockedUntil) && now.Sub(failures.lastFailure) > loginLockout {
+			delete(sm.failures, client)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+type CollisionMode string
+
+const (
+	// CollisionNone lets octapods pass through and share cells
+	CollisionNone CollisionMode = "none"
+	// CollisionNoSwap blocks two octapods from swapping cells, sharing is allowed
+	CollisionNoSwap CollisionMode = "noswap"
+	// CollisionExclusive allows a single octapod per cell, swaps are blocked as well
+	CollisionExclusive CollisionMode = "exclusive"
+)
+
+func (m CollisionMode) IsValid() bool {
+	return m == CollisionNone || m == CollisionNoSwap || m == CollisionExclusive
+}
+
+// plannedMove is where an octapod wants to go this tick
+type plannedMove struct {
+	id   string
+	from pkg.Vector
+	to   pkg.Vector
+	// reverted is set when the move was sent back by the collision rules
+	reverted bool
+}
+
+func (p *plannedMove) isMoving() bool {
+	return p.from != p.to
+}
+
+func (p *plannedMove) revert() {
+	p.to = p.from
+	p.reverted = true
+}
+
+// resolveCollisions sends back the moves that break the collision rules.
+// Resolution is deterministic: an octapod that stays put keeps its cell,
+// otherwise the lowest id wins the contested cell and the others stay.
+// Octapods already sharing a cell, like at the start, are left alone.
+// The moves must be sorted by id, see UpdateAll.
+func resolveCollisions(moves []*plannedMove, mode CollisionMode) {
+	if mode == CollisionNone {
+		return
+	}
+
+	blockSwaps(moves)
+	if mode != CollisionExclusive {
+		return
+	}
+
+	// Every reverted move can contest the cell it stays in, so repeat until settled
+	for {
+		claims := make(map[pkg.Vector][]*plannedMove)
+		for _, move := range moves {
+			claims[move.to] = append(claims[move.to], move)
+		}
+
+		reverted := false
+		for _, claimants := range claims {
+			if len(claimants) < 2 {
+				continue
+			}
+
+			winner := claimants[0]
+			for _, claimant := range claimants {
+				if !claimant.isMoving() {
+					winner = nil
+					break
+				}
+			}
+
+			for _, claimant := range claimants {
+				if claimant != winner && claimant.isMoving() {
+					claimant.revert()
+					reverted = true
+				}
+			}
+		}
+
+		if !reverted {
+			return
+		}
+	}
+}
+
+func blockSwaps(moves []*plannedMove) {
+	from := make(map[pkg.Vector][]*plannedMove)
+	for _, move := range moves {
+		if move.isMoving() {
+			from[move.from] = append(from[move.from], move)
+		}
+	}
+
+	swapped := make([]*plannedMove, 0)
+	for _, move := range moves {
+		if !move.isMoving() {
+			continue
+		}
+		for _, other := range from[move.to] {
+			if other != move && other.to == move.from {
+				swapped = append(swapped, move)
+				break
+			}
+		}
+	}
+
+	for _, move := range swapped {
+		move.revert()
+	}
+}
@@ -1,35 +1,591 @@
 package server
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"gbccsclub/octopod-challenge/internal/model"
+	"gbccsclub/octopod-challenge/pkg"
 	"sync"
 )
 
 type Config struct {
+	// mu guards every field. Export and the helpers building the config of each feature take it,
+	// so their callers must not hold it.
 	mu sync.RWMutex
 
 	// Loop
 	TickInterval int
+	FastMode     bool
 
 	// Competition settings
 	MaxExplorationSteps int
 	MaxSolvingSteps     int
+	ActionPoints        int
+	DashCost            int
+	CollisionMode       CollisionMode
+
+	// Teams
+	TeamMode         bool
+	TeamMessageLimit int
+
+	// Connections, see ConnectionConfig
+	IdleTimeout    int
+	AfkTicks       int
+	ResponseWindow int
 
 	// Maze
-	MazeSize int
+	MazeSize       int
+	Topology       pkg.Topology
+	Floors         int
+	StairsPerFloor int
+
+	// Dynamic maze, see DynamicsConfig
+	DoorCount         int
+	DoorPeriod        int
+	WallShiftInterval int
+	WallShiftCount    int
+
+	// Special tiles, see TileConfig
+	CoinCount       int
+	KeyCount        int
+	LockCount       int
+	OneWayCount     int
+	TeleporterCount int
+	MudCount        int
+	MudCost         int
+	IceCount        int
+	TrapCount       int
+
+	// Solve condition and scoring, see Objectives
+	SolveRequiresKey bool
+	SolveMinCoins    int
+	CoinScore        int
+	SolveScore       int
+	PathScore        int
+
+	// Optional sensors, see SensorConfig
+	SensorRangeFinder bool
+	SensorCompass     bool
+	SensorSignal      bool
+	SensorProximity   bool
+	ProximityRadius   int
 
 	// Discord
 	DiscordBotToken  string
 	DiscordChannelId string
 }
 
+// ConfigView is the admin view of the settings, without the Discord bot token
+type ConfigView struct {
+	TickInterval        int           `json:"tickInterval"`
+	FastMode            bool          `json:"fastMode"`
+	MaxExplorationSteps int           `json:"maxExplorationSteps"`
+	MaxSolvingSteps     int           `json:"maxSolvingSteps"`
+	ActionPoints        int           `json:"actionPoints"`
+	DashCost            int           `json:"dashCost"`
+	CollisionMode       CollisionMode `json:"collisionMode"`
+	TeamMode            bool          `json:"teamMode"`
+	TeamMessageLimit    int           `json:"teamMessageLimit"`
+	IdleTimeout         int           `json:"idleTimeout"`
+	AfkTicks            int           `json:"afkTicks"`
+	ResponseWindow      int           `json:"responseWindow"`
+	MazeSize            int           `json:"mazeSize"`
+	Topology            pkg.Topology  `json:"topology"`
+	Floors              int           `json:"floors"`
+	StairsPerFloor      int           `json:"stairsPerFloor"`
+	DoorCount           int           `json:"doorCount"`
+	DoorPeriod          int           `json:"doorPeriod"`
+	WallShiftInterval   int           `json:"wallShiftInterval"`
+	WallShiftCount      int           `json:"wallShiftCount"`
+	CoinCount           int           `json:"coinCount"`
+	KeyCount            int           `json:"keyCount"`
+	LockCount           int           `json:"lockCount"`
+	OneWayCount         int           `json:"oneWayCount"`
+	TeleporterCount     int           `json:"teleporterCount"`
+	MudCount            int           `json:"mudCount"`
+	MudCost             int           `json:"mudCost"`
+	IceCount            int           `json:"iceCount"`
+	TrapCount           int           `json:"trapCount"`
+	SolveRequiresKey    bool          `json:"solveRequiresKey"`
+	SolveMinCoins       int           `json:"solveMinCoins"`
+	CoinScore           int           `json:"coinScore"`
+	SolveScore          int           `json:"solveScore"`
+	PathScore           int           `json:"pathScore"`
+	SensorRangeFinder   bool          `json:"sensorRangeFinder"`
+	SensorCompass       bool          `json:"sensorCompass"`
+	SensorSignal        bool          `json:"sensorSignal"`
+	SensorProximity     bool          `json:"sensorProximity"`
+	ProximityRadius     int           `json:"proximityRadius"`
+	DiscordChannelId    string        `json:"discordChannelId"`
+}
+
+// ConfigUpdate is a partial update of the settings, nil fields are left untouched.
+// It is shared by the admin panel form and the JSON API.
+type ConfigUpdate struct {
+	TickInterval        *int           `json:"tickInterval" form:"tick_interval" yaml:"tickInterval,omitempty"`
+	FastMode            *bool          `json:"fastMode" form:"fast_mode" yaml:"fastMode,omitempty"`
+	MaxExplorationSteps *int           `json:"maxExplorationSteps" form:"max_exploration_steps" yaml:"maxExplorationSteps,omitempty"`
+	MaxSolvingSteps     *int           `json:"maxSolvingSteps" form:"max_solving_steps" yaml:"maxSolvingSteps,omitempty"`
+	ActionPoints        *int           `json:"actionPoints" form:"action_points" yaml:"actionPoints,omitempty"`
+	DashCost            *int           `json:"dashCost" form:"dash_cost" yaml:"dashCost,omitempty"`
+	CollisionMode       *CollisionMode `json:"collisionMode" form:"collision_mode" yaml:"collisionMode,omitempty"`
+	TeamMode            *bool          `json:"teamMode" form:"team_mode" yaml:"teamMode,omitempty"`
+	TeamMessageLimit    *int           `json:"teamMessageLimit" form:"team_message_limit" yaml:"teamMessageLimit,omitempty"`
+	IdleTimeout         *int           `json:"idleTimeout" form:"idle_timeout" yaml:"idleTimeout,omitempty"`
+	AfkTicks            *int           `json:"afkTicks" form:"afk_ticks" yaml:"afkTicks,omitempty"`
+	ResponseWindow      *int           `json:"responseWindow" form:"response_window" yaml:"responseWindow,omitempty"`
+	MazeSize            *int           `json:"mazeSize" form:"maze_size" yaml:"mazeSize,omitempty"`
+	Topology            *pkg.Topology  `json:"topology" form:"topology" yaml:"topology,omitempty"`
+	Floors              *int           `json:"floors" form:"floors" yaml:"floors,omitempty"`
+	StairsPerFloor      *int           `json:"stairsPerFloor" form:"stairs_per_floor" yaml:"stairsPerFloor,omitempty"`
+	DoorCount           *int           `json:"doorCount" form:"door_count" yaml:"doorCount,omitempty"`
+	DoorPeriod          *int           `json:"doorPeriod" form:"door_period" yaml:"doorPeriod,omitempty"`
+	WallShiftInterval   *int           `json:"wallShiftInterval" form:"wall_shift_interval" yaml:"wallShiftInterval,omitempty"`
+	WallShiftCount      *int           `json:"wallShiftCount" form:"wall_shift_count" yaml:"wallShiftCount,omitempty"`
+	CoinCount           *int           `json:"coinCount" form:"coin_count" yaml:"coinCount,omitempty"`
+	KeyCount            *int           `json:"keyCount" form:"key_count" yaml:"keyCount,omitempty"`
+	LockCount           *int           `json:"lockCount" form:"lock_count" yaml:"lockCount,omitempty"`
+	OneWayCount         *int           `json:"oneWayCount" form:"one_way_count" yaml:"oneWayCount,omitempty"`
+	TeleporterCount     *int           `json:"teleporterCount" form:"teleporter_count" yaml:"teleporterCount,omitempty"`
+	MudCount            *int           `json:"mudCount" form:"mud_count" yaml:"mudCount,omitempty"`
+	MudCost             *int           `json:"mudCost" form:"mud_cost" yaml:"mudCost,omitempty"`
+	IceCount            *int           `json:"iceCount" form:"ice_count" yaml:"iceCount,omitempty"`
+	TrapCount           *int           `json:"trapCount" form:"trap_count" yaml:"trapCount,omitempty"`
+	SolveRequiresKey    *bool          `json:"solveRequiresKey" form:"solve_requires_key" yaml:"solveRequiresKey,omitempty"`
+	SolveMinCoins       *int           `json:"solveMinCoins" form:"solve_min_coins" yaml:"solveMinCoins,omitempty"`
+	CoinScore           *int           `json:"coinScore" form:"coin_score" yaml:"coinScore,omitempty"`
+	SolveScore          *int           `json:"solveScore" form:"solve_score" yaml:"solveScore,omitempty"`
+	PathScore           *int           `json:"pathScore" form:"path_score" yaml:"pathScore,omitempty"`
+	SensorRangeFinder   *bool          `json:"sensorRangeFinder" form:"sensor_range_finder" yaml:"sensorRangeFinder,omitempty"`
+	SensorCompass       *bool          `json:"sensorCompass" form:"sensor_compass" yaml:"sensorCompass,omitempty"`
+	SensorSignal        *bool          `json:"sensorSignal" form:"sensor_signal" yaml:"sensorSignal,omitempty"`
+	SensorProximity     *bool          `json:"sensorProximity" form:"sensor_proximity" yaml:"sensorProximity,omitempty"`
+	ProximityRadius     *int           `json:"proximityRadius" form:"proximity_radius" yaml:"proximityRadius,omitempty"`
+	DiscordBotToken     *string        `json:"discordBotToken" form:"discord_bot_token" yaml:"discordBotToken,omitempty"`
+	DiscordChannelId    *string        `json:"discordChannelId" form:"discord_channel_id" yaml:"discordChannelId,omitempty"`
+}
+
+// ConfigChanges tells which parts of the lobby are affected by an update
+type ConfigChanges struct {
+	// Applied live
+	Ticker      bool
+	Notifier    bool
+	Connections bool
+	// Rules are the steps and the actions, sent to the octapods joining during the round
+	Rules bool
+
+	// Needs a new round
+	Round bool
+}
+
+func (c ConfigChanges) Any() bool {
+	return c.Ticker || c.Notifier || c.Connections || c.Rules || c.Round
+}
+
+// NewConfig returns the defaults, see LoadConfig for the file and env layers
 func NewConfig() *Config {
 	return &Config{
 		TickInterval:        3000,
 		MaxExplorationSteps: 2 * 10 * 10,
 		MaxSolvingSteps:     5 * 10,
+		ActionPoints:        1,
+		DashCost:            1,
+		CollisionMode:       CollisionNone,
+		TeamMessageLimit:    256,
 		MazeSize:            10,
-		DiscordBotToken:     os.Getenv("DISCORD_BOT_TOKEN"),
-		DiscordChannelId:    os.Getenv("DISCORD_CHANNEL_ID"),
+		Topology:            pkg.Square,
+		DoorPeriod:          10,
+		WallShiftCount:      3,
+		CoinScore:           1,
+		SolveScore:          10,
+		MudCost:             2,
+		PathScore:           10,
+		Floors:              1,
+		StairsPerFloor:      2,
+		IdleTimeout:         60,
+		ProximityRadius:     3,
+	}
+}
+
+// Validate checks the given fields against their allowed ranges
+func (u ConfigUpdate) Validate() error {
+	return errors.Join(
+		checkRange("tickInterval", u.TickInterval, 50, 60000),
+		checkRange("maxExplorationSteps", u.MaxExplorationSteps, 1, 100000),
+		checkRange("maxSolvingSteps", u.MaxSolvingSteps, 1, 100000),
+		checkRange("actionPoints", u.ActionPoints, 1, 20),
+		checkRange("dashCost", u.DashCost, 1, 10),
+		checkCollisionMode(u.CollisionMode),
+		checkRange("teamMessageLimit", u.TeamMessageLimit, 16, 4096),
+		checkRange("idleTimeout", u.IdleTimeout, 5, 600),
+		checkRange("afkTicks", u.AfkTicks, 0, 10000),
+		checkRange("responseWindow", u.ResponseWindow, 0, 60000),
+		checkRange("mazeSize", u.MazeSize, 5, 50),
+		checkTopology(u.Topology),
+		checkRange("floors", u.Floors, 1, 5),
+		checkRange("stairsPerFloor", u.StairsPerFloor, 1, 10),
+		checkRange("doorCount", u.DoorCount, 0, 50),
+		checkRange("doorPeriod", u.DoorPeriod, 1, 1000),
+		checkRange("wallShiftInterval", u.WallShiftInterval, 0, 1000),
+		checkRange("wallShiftCount", u.WallShiftCount, 1, 50),
+		checkRange("coinCount", u.CoinCount, 0, 50),
+		checkRange("keyCount", u.KeyCount, 0, 10),
+		checkRange("lockCount", u.LockCount, 0, 10),
+		checkRange("oneWayCount", u.OneWayCount, 0, 50),
+		checkRange("teleporterCount", u.TeleporterCount, 0, 10),
+		checkRange("mudCount", u.MudCount, 0, 50),
+		checkRange("mudCost", u.MudCost, 1, 10),
+		checkRange("iceCount", u.IceCount, 0, 50),
+		checkRange("trapCount", u.TrapCount, 0, 20),
+		checkRange("solveMinCoins", u.SolveMinCoins, 0, 50),
+		checkRange("coinScore", u.CoinScore, 0, 1000),
+		checkRange("solveScore", u.SolveScore, 0, 1000),
+		checkRange("pathScore", u.PathScore, 0, 1000),
+		checkRange("proximityRadius", u.ProximityRadius, 1, 10),
+	)
+}
+
+// validate checks the objectives the update leads to against the tiles placed, with the settings
+// the update leaves untouched taken from the config. The caller must hold the lock.
+func (c *Config) validate(u ConfigUpdate) error {
+	return checkObjectives(valueOr(u.SolveRequiresKey, c.SolveRequiresKey), valueOr(u.KeyCount, c.KeyCount),
+		valueOr(u.SolveMinCoins, c.SolveMinCoins), valueOr(u.CoinCount, c.CoinCount))
+}
+
+// Merge returns u with the fields set in other on top
+func (u ConfigUpdate) Merge(other ConfigUpdate) ConfigUpdate {
+	mergeField(&u.TickInterval, other.TickInterval)
+	mergeField(&u.FastMode, other.FastMode)
+	mergeField(&u.MaxExplorationSteps, other.MaxExplorationSteps)
+	mergeField(&u.MaxSolvingSteps, other.MaxSolvingSteps)
+	mergeField(&u.ActionPoints, other.ActionPoints)
+	mergeField(&u.DashCost, other.DashCost)
+	mergeField(&u.CollisionMode, other.CollisionMode)
+	mergeField(&u.TeamMode, other.TeamMode)
+	mergeField(&u.TeamMessageLimit, other.TeamMessageLimit)
+	mergeField(&u.IdleTimeout, other.IdleTimeout)
+	mergeField(&u.AfkTicks, other.AfkTicks)
+	mergeField(&u.ResponseWindow, other.ResponseWindow)
+	mergeField(&u.MazeSize, other.MazeSize)
+	mergeField(&u.Topology, other.Topology)
+	mergeField(&u.Floors, other.Floors)
+	mergeField(&u.StairsPerFloor, other.StairsPerFloor)
+	mergeField(&u.DoorCount, other.DoorCount)
+	mergeField(&u.DoorPeriod, other.DoorPeriod)
+	mergeField(&u.WallShiftInterval, other.WallShiftInterval)
+	mergeField(&u.WallShiftCount, other.WallShiftCount)
+	mergeField(&u.CoinCount, other.CoinCount)
+	mergeField(&u.KeyCount, other.KeyCount)
+	mergeField(&u.LockCount, other.LockCount)
+	mergeField(&u.OneWayCount, other.OneWayCount)
+	mergeField(&u.TeleporterCount, other.TeleporterCount)
+	mergeField(&u.MudCount, other.MudCount)
+	mergeField(&u.MudCost, other.MudCost)
+	mergeField(&u.IceCount, other.IceCount)
+	mergeField(&u.TrapCount, other.TrapCount)
+	mergeField(&u.SolveRequiresKey, other.SolveRequiresKey)
+	mergeField(&u.SolveMinCoins, other.SolveMinCoins)
+	mergeField(&u.CoinScore, other.CoinScore)
+	mergeField(&u.SolveScore, other.SolveScore)
+	mergeField(&u.PathScore, other.PathScore)
+	mergeField(&u.SensorRangeFinder, other.SensorRangeFinder)
+	mergeField(&u.SensorCompass, other.SensorCompass)
+	mergeField(&u.SensorSignal, other.SensorSignal)
+	mergeField(&u.SensorProximity, other.SensorProximity)
+	mergeField(&u.ProximityRadius, other.ProximityRadius)
+	if other.DiscordBotToken != nil && *other.DiscordBotToken != "" {
+		u.DiscordBotToken = other.DiscordBotToken
+	}
+	mergeField(&u.DiscordChannelId, other.DiscordChannelId)
+	return u
+}
+
+func mergeField[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+// applyField writes src to dst when set and reports whether the value changed
+func applyField[T comparable](dst *T, src *T) bool {
+	if src == nil || *src == *dst {
+		return false
+	}
+	*dst = *src
+	return true
+}
+
+func checkRange(name string, value *int, min int, max int) error {
+	if value == nil || (*value >= min && *value <= max) {
+		return nil
+	}
+	return fmt.Errorf("%s must be between %d and %d", name, min, max)
+}
+
+// checkObjectives refuses solve conditions that no octapod could meet
+func checkObjectives(requiresKey bool, keyCount int, minCoins int, coinCount int) error {
+	if requiresKey && keyCount == 0 {
+		return errors.New("solveRequiresKey needs at least one key, keyCount is 0")
+	}
+	if minCoins > coinCount {
+		return fmt.Errorf("solveMinCoins must not exceed coinCount (%d)", coinCount)
+	}
+	return nil
+}
+
+func valueOr[T any](value *T, fallback T) T {
+	if value == nil {
+		return fallback
 	}
+	return *value
+}
+
+func checkTopology(topology *pkg.Topology) error {
+	if topology == nil || topology.IsValid() {
+		return nil
+	}
+	return fmt.Errorf("topology must be one of %s, %s or %s", pkg.Square, pkg.Hex, pkg.Torus)
+}
+
+func checkCollisionMode(mode *CollisionMode) error {
+	if mode == nil || mode.IsValid() {
+		return nil
+	}
+	return fmt.Errorf("collisionMode must be one of %s, %s or %s", CollisionNone, CollisionNoSwap, CollisionExclusive)
+}
+
+func (c *Config) Export() ConfigView {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return ConfigView{
+		TickInterval:        c.TickInterval,
+		FastMode:            c.FastMode,
+		MaxExplorationSteps: c.MaxExplorationSteps,
+		MaxSolvingSteps:     c.MaxSolvingSteps,
+		ActionPoints:        c.ActionPoints,
+		DashCost:            c.DashCost,
+		CollisionMode:       c.CollisionMode,
+		TeamMode:            c.TeamMode,
+		TeamMessageLimit:    c.TeamMessageLimit,
+		IdleTimeout:         c.IdleTimeout,
+		AfkTicks:            c.AfkTicks,
+		ResponseWindow:      c.ResponseWindow,
+		MazeSize:            c.MazeSize,
+		Topology:            c.Topology,
+		Floors:              c.Floors,
+		StairsPerFloor:      c.StairsPerFloor,
+		DoorCount:           c.DoorCount,
+		DoorPeriod:          c.DoorPeriod,
+		WallShiftInterval:   c.WallShiftInterval,
+		WallShiftCount:      c.WallShiftCount,
+		CoinCount:           c.CoinCount,
+		KeyCount:            c.KeyCount,
+		LockCount:           c.LockCount,
+		OneWayCount:         c.OneWayCount,
+		TeleporterCount:     c.TeleporterCount,
+		MudCount:            c.MudCount,
+		MudCost:             c.MudCost,
+		IceCount:            c.IceCount,
+		TrapCount:           c.TrapCount,
+		SolveRequiresKey:    c.SolveRequiresKey,
+		SolveMinCoins:       c.SolveMinCoins,
+		CoinScore:           c.CoinScore,
+		SolveScore:          c.SolveScore,
+		PathScore:           c.PathScore,
+		SensorRangeFinder:   c.SensorRangeFinder,
+		SensorCompass:       c.SensorCompass,
+		SensorSignal:        c.SensorSignal,
+		SensorProximity:     c.SensorProximity,
+		ProximityRadius:     c.ProximityRadius,
+		DiscordChannelId:    c.DiscordChannelId,
+	}
+}
+
+// Update returns every setting of the view as an update, see NewConfig for the defaults a reload starts from
+func (v ConfigView) Update() ConfigUpdate {
+	return ConfigUpdate{
+		TickInterval:        &v.TickInterval,
+		FastMode:            &v.FastMode,
+		MaxExplorationSteps: &v.MaxExplorationSteps,
+		MaxSolvingSteps:     &v.MaxSolvingSteps,
+		ActionPoints:        &v.ActionPoints,
+		DashCost:            &v.DashCost,
+		CollisionMode:       &v.CollisionMode,
+		TeamMode:            &v.TeamMode,
+		TeamMessageLimit:    &v.TeamMessageLimit,
+		IdleTimeout:         &v.IdleTimeout,
+		AfkTicks:            &v.AfkTicks,
+		ResponseWindow:      &v.ResponseWindow,
+		MazeSize:            &v.MazeSize,
+		Topology:            &v.Topology,
+		Floors:              &v.Floors,
+		StairsPerFloor:      &v.StairsPerFloor,
+		DoorCount:           &v.DoorCount,
+		DoorPeriod:          &v.DoorPeriod,
+		WallShiftInterval:   &v.WallShiftInterval,
+		WallShiftCount:      &v.WallShiftCount,
+		CoinCount:           &v.CoinCount,
+		KeyCount:            &v.KeyCount,
+		LockCount:           &v.LockCount,
+		OneWayCount:         &v.OneWayCount,
+		TeleporterCount:     &v.TeleporterCount,
+		MudCount:            &v.MudCount,
+		MudCost:             &v.MudCost,
+		IceCount:            &v.IceCount,
+		TrapCount:           &v.TrapCount,
+		SolveRequiresKey:    &v.SolveRequiresKey,
+		SolveMinCoins:       &v.SolveMinCoins,
+		CoinScore:           &v.CoinScore,
+		SolveScore:          &v.SolveScore,
+		PathScore:           &v.PathScore,
+		SensorRangeFinder:   &v.SensorRangeFinder,
+		SensorCompass:       &v.SensorCompass,
+		SensorSignal:        &v.SensorSignal,
+		SensorProximity:     &v.SensorProximity,
+		ProximityRadius:     &v.ProximityRadius,
+		DiscordChannelId:    &v.DiscordChannelId,
+	}
+}
+
+// Changed returns the fields of u that differ from the current settings.
+// The bot token is never returned, secrets stay in env vars and are not persisted.
+func (u ConfigUpdate) Changed(current ConfigView) ConfigUpdate {
+	return ConfigUpdate{
+		TickInterval:        changedField(u.TickInterval, current.TickInterval),
+		FastMode:            changedField(u.FastMode, current.FastMode),
+		MaxExplorationSteps: changedField(u.MaxExplorationSteps, current.MaxExplorationSteps),
+		MaxSolvingSteps:     changedField(u.MaxSolvingSteps, current.MaxSolvingSteps),
+		ActionPoints:        changedField(u.ActionPoints, current.ActionPoints),
+		DashCost:            changedField(u.DashCost, current.DashCost),
+		CollisionMode:       changedField(u.CollisionMode, current.CollisionMode),
+		TeamMode:            changedField(u.TeamMode, current.TeamMode),
+		TeamMessageLimit:    changedField(u.TeamMessageLimit, current.TeamMessageLimit),
+		IdleTimeout:         changedField(u.IdleTimeout, current.IdleTimeout),
+		AfkTicks:            changedField(u.AfkTicks, current.AfkTicks),
+		ResponseWindow:      changedField(u.ResponseWindow, current.ResponseWindow),
+		MazeSize:            changedField(u.MazeSize, current.MazeSize),
+		Topology:            changedField(u.Topology, current.Topology),
+		Floors:              changedField(u.Floors, current.Floors),
+		StairsPerFloor:      changedField(u.StairsPerFloor, current.StairsPerFloor),
+		DoorCount:           changedField(u.DoorCount, current.DoorCount),
+		DoorPeriod:          changedField(u.DoorPeriod, current.DoorPeriod),
+		WallShiftInterval:   changedField(u.WallShiftInterval, current.WallShiftInterval),
+		WallShiftCount:      changedField(u.WallShiftCount, current.WallShiftCount),
+		CoinCount:           changedField(u.CoinCount, current.CoinCount),
+		KeyCount:            changedField(u.KeyCount, current.KeyCount),
+		LockCount:           changedField(u.LockCount, current.LockCount),
+		OneWayCount:         changedField(u.OneWayCount, current.OneWayCount),
+		TeleporterCount:     changedField(u.TeleporterCount, current.TeleporterCount),
+		MudCount:            changedField(u.MudCount, current.MudCount),
+		MudCost:             changedField(u.MudCost, current.MudCost),
+		IceCount:            changedField(u.IceCount, current.IceCount),
+		TrapCount:           changedField(u.TrapCount, current.TrapCount),
+		SolveRequiresKey:    changedField(u.SolveRequiresKey, current.SolveRequiresKey),
+		SolveMinCoins:       changedField(u.SolveMinCoins, current.SolveMinCoins),
+		CoinScore:           changedField(u.CoinScore, current.CoinScore),
+		SolveScore:          changedField(u.SolveScore, current.SolveScore),
+		PathScore:           changedField(u.PathScore, current.PathScore),
+		SensorRangeFinder:   changedField(u.SensorRangeFinder, current.SensorRangeFinder),
+		SensorCompass:       changedField(u.SensorCompass, current.SensorCompass),
+		SensorSignal:        changedField(u.SensorSignal, current.SensorSignal),
+		SensorProximity:     changedField(u.SensorProximity, current.SensorProximity),
+		ProximityRadius:     changedField(u.ProximityRadius, current.ProximityRadius),
+		DiscordChannelId:    changedField(u.DiscordChannelId, current.DiscordChannelId),
+	}
+}
+
+// changedField returns value when it is set and differs from current
+func changedField[T comparable](value *T, current T) *T {
+	if value == nil || *value == current {
+		return nil
+	}
+	return value
+}
+
+// Apply validates the update and writes the given fields, then reports what changed.
+// Both happen under the same lock, so concurrent updates can't add up to settings that would not validate.
+// An empty bot token keeps the current one, since the panel never shows it.
+func (c *Config) Apply(u ConfigUpdate) (ConfigChanges, error) {
+	if err := u.Validate(); err != nil {
+		return ConfigChanges{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.validate(u); err != nil {
+		return ConfigChanges{}, err
+	}
+
+	var changes ConfigChanges
+
+	changes.Ticker = applyField(&c.TickInterval, u.TickInterval)
+	applyField(&c.FastMode, u.FastMode)
+
+	changes.Rules = applyField(&c.MaxExplorationSteps, u.MaxExplorationSteps)
+	changes.Rules = applyField(&c.MaxSolvingSteps, u.MaxSolvingSteps) || changes.Rules
+	changes.Rules = applyField(&c.ActionPoints, u.ActionPoints) || changes.Rules
+	changes.Rules = applyField(&c.DashCost, u.DashCost) || changes.Rules
+	changes.Rules = applyField(&c.CollisionMode, u.CollisionMode) || changes.Rules
+	applyField(&c.TeamMode, u.TeamMode)
+	applyField(&c.TeamMessageLimit, u.TeamMessageLimit)
+	changes.Connections = applyField(&c.IdleTimeout, u.IdleTimeout)
+	applyField(&c.AfkTicks, u.AfkTicks)
+	changes.Connections = applyField(&c.ResponseWindow, u.ResponseWindow) || changes.Connections
+
+	changes.Round = applyField(&c.MazeSize, u.MazeSize) || changes.Round
+	changes.Round = applyField(&c.Topology, u.Topology) || changes.Round
+	changes.Round = applyField(&c.Floors, u.Floors) || changes.Round
+	changes.Round = applyField(&c.StairsPerFloor, u.StairsPerFloor) || changes.Round
+	changes.Round = applyField(&c.DoorCount, u.DoorCount) || changes.Round
+	changes.Round = applyField(&c.DoorPeriod, u.DoorPeriod) || changes.Round
+	changes.Round = applyField(&c.WallShiftInterval, u.WallShiftInterval) || changes.Round
+	changes.Round = applyField(&c.WallShiftCount, u.WallShiftCount) || changes.Round
+	changes.Round = applyField(&c.CoinCount, u.CoinCount) || changes.Round
+	changes.Round = applyField(&c.KeyCount, u.KeyCount) || changes.Round
+	changes.Round = applyField(&c.LockCount, u.LockCount) || changes.Round
+	changes.Round = applyField(&c.OneWayCount, u.OneWayCount) || changes.Round
+	changes.Round = applyField(&c.TeleporterCount, u.TeleporterCount) || changes.Round
+	changes.Round = applyField(&c.MudCount, u.MudCount) || changes.Round
+	changes.Round = applyField(&c.MudCost, u.MudCost) || changes.Round
+	changes.Round = applyField(&c.IceCount, u.IceCount) || changes.Round
+	changes.Round = applyField(&c.TrapCount, u.TrapCount) || changes.Round
+	changes.Round = applyField(&c.SolveRequiresKey, u.SolveRequiresKey) || changes.Round
+	changes.Round = applyField(&c.SolveMinCoins, u.SolveMinCoins) || changes.Round
+	changes.Round = applyField(&c.CoinScore, u.CoinScore) || changes.Round
+	changes.Round = applyField(&c.SolveScore, u.SolveScore) || changes.Round
+	changes.Round = applyField(&c.PathScore, u.PathScore) || changes.Round
+	changes.Round = applyField(&c.SensorRangeFinder, u.SensorRangeFinder) || changes.Round
+	changes.Round = applyField(&c.SensorCompass, u.SensorCompass) || changes.Round
+	changes.Round = applyField(&c.SensorSignal, u.SensorSignal) || changes.Round
+	changes.Round = applyField(&c.SensorProximity, u.SensorProximity) || changes.Round
+	changes.Round = applyField(&c.ProximityRadius, u.ProximityRadius) || changes.Round
+
+	if u.DiscordBotToken != nil && *u.DiscordBotToken != "" {
+		changes.Notifier = applyField(&c.DiscordBotToken, u.DiscordBotToken) || changes.Notifier
+	}
+	changes.Notifier = applyField(&c.DiscordChannelId, u.DiscordChannelId) || changes.Notifier
+
+	return changes, nil
+}
+
+func (c *Config) actionRules() model.ActionRules {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return model.ActionRules{
+		Points:   c.ActionPoints,
+		DashCost: c.DashCost,
+	}
+}
+
+func (c *Config) fastMode() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FastMode
+}
+
+func (c *Config) collisionMode() CollisionMode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CollisionMode
 }
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"errors"
+	"gbccsclub/octopod-challenge/pkg"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configWatchInterval = 2 * time.Second
+
+// ConfigStore reads the config file and persists admin edits, watching both for outside changes.
+// Admin edits are kept in their own overlay file next to the config file, so they win over env vars
+// without being mixed with the settings written by hand. Both files only hold the fields that were set,
+// everything else keeps its default. The overlay never holds secrets, the bot token only comes from env vars.
+type ConfigStore struct {
+	mu        sync.Mutex
+	path      string
+	adminPath string
+	modTimes  map[string]time.Time
+}
+
+// NewConfigStore keeps admin edits of config.yaml in config.admin.yaml
+func NewConfigStore(path string) *ConfigStore {
+	ext := filepath.Ext(path)
+	return &ConfigStore{
+		path:      path,
+		adminPath: strings.TrimSuffix(path, ext) + ".admin" + ext,
+		modTimes:  make(map[string]time.Time),
+	}
+}
+
+// LoadConfig layers the defaults, the config file, the env vars and the admin edits, in that order
+func LoadConfig(store *ConfigStore) (*Config, error) {
+	config := NewConfig()
+
+	update, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = config.Apply(update); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Load returns the defaults overlaid with the config file, the env vars and the admin edits.
+// Every field is set, so a setting removed from a file goes back to its default.
+func (s *ConfigStore) Load() (ConfigUpdate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.layers()
+}
+
+func (s *ConfigStore) layers() (ConfigUpdate, error) {
+	fileUpdate, err := s.load(s.path)
+	if err != nil {
+		return ConfigUpdate{}, err
+	}
+	adminUpdate, err := s.load(s.adminPath)
+	if err != nil {
+		return ConfigUpdate{}, err
+	}
+	defaults := NewConfig().Export().Update()
+	return defaults.Merge(fileUpdate).Merge(envConfigUpdate()).Merge(adminUpdate), nil
+}
+
+// load reads a single file, a missing file is an empty update
+func (s *ConfigStore) load(path string) (ConfigUpdate, error) {
+	var update ConfigUpdate
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		delete(s.modTimes, path)
+		return update, nil
+	}
+	if err != nil {
+		return update, err
+	}
+	s.modTimes[path] = info.ModTime()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return update, err
+	}
+
+	err = yaml.Unmarshal(data, &update)
+	return update, err
+}
+
+// Save merges the update into the admin edits, only pass the fields that changed, see ConfigUpdate.Changed.
+// The bot token is left out, it would be written in plain text.
+func (s *ConfigStore) Save(update ConfigUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.load(s.adminPath)
+	if err != nil {
+		return err
+	}
+
+	edits := current.Merge(update)
+	edits.DiscordBotToken = nil
+	data, err := yaml.Marshal(edits)
+	if err != nil {
+		return err
+	}
+
+	// Write through a temp file so the watcher never sees a half written config, only the owner can read it
+	tmp, err := os.CreateTemp(filepath.Dir(s.adminPath), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.adminPath); err != nil {
+		return err
+	}
+
+	// Our own write is not an outside change
+	if info, err := os.Stat(s.adminPath); err == nil {
+		s.modTimes[s.adminPath] = info.ModTime()
+	}
+	return nil
+}
+
+// Watch polls both files and calls onChange with every layer, like LoadConfig,
+// whenever somebody else edits one of them.
+func (s *ConfigStore) Watch(onChange func(update ConfigUpdate)) {
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		update, changed, err := s.reloadIfChanged()
+		if err != nil {
+			log.Printf("Failed to reload config file %s: %v\n", s.path, err)
+			continue
+		}
+		if changed {
+			log.Printf("Config file %s changed, reloading\n", s.path)
+			onChange(update)
+		}
+	}
+}
+
+func (s *ConfigStore) reloadIfChanged() (ConfigUpdate, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, path := range []string{s.path, s.adminPath} {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			_, seen := s.modTimes[path]
+			changed = changed || seen
+			continue
+		}
+		if err != nil {
+			return ConfigUpdate{}, false, err
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return ConfigUpdate{}, false, nil
+	}
+
+	update, err := s.layers()
+	return update, err == nil, err
+}
+
+// envConfigUpdate reads the settings overridden through env vars
+func envConfigUpdate() ConfigUpdate {
+	return ConfigUpdate{
+		TickInterval:        envInt("OCTAPOD_TICK_INTERVAL"),
+		FastMode:            envBool("OCTAPOD_FAST_MODE"),
+		MaxExplorationSteps: envInt("OCTAPOD_MAX_EXPLORATION_STEPS"),
+		MaxSolvingSteps:     envInt("OCTAPOD_MAX_SOLVING_STEPS"),
+		ActionPoints:        envInt("OCTAPOD_ACTION_POINTS"),
+		DashCost:            envInt("OCTAPOD_DASH_COST"),
+		CollisionMode:       (*CollisionMode)(envString("OCTAPOD_COLLISION_MODE")),
+		TeamMode:            envBool("OCTAPOD_TEAM_MODE"),
+		TeamMessageLimit:    envInt("OCTAPOD_TEAM_MESSAGE_LIMIT"),
+		IdleTimeout:         envInt("OCTAPOD_IDLE_TIMEOUT"),
+		AfkTicks:            envInt("OCTAPOD_AFK_TICKS"),
+		ResponseWindow:      envInt("OCTAPOD_RESPONSE_WINDOW"),
+		MazeSize:            envInt("OCTAPOD_MAZE_SIZE"),
+		Topology:            (*pkg.Topology)(envString("OCTAPOD_TOPOLOGY")),
+		Floors:              envInt("OCTAPOD_FLOORS"),
+		StairsPerFloor:      envInt("OCTAPOD_STAIRS_PER_FLOOR"),
+		DoorCount:           envInt("OCTAPOD_DOOR_COUNT"),
+		DoorPeriod:          envInt("OCTAPOD_DOOR_PERIOD"),
+		WallShiftInterval:   envInt("OCTAPOD_WALL_SHIFT_INTERVAL"),
+		WallShiftCount:      envInt("OCTAPOD_WALL_SHIFT_COUNT"),
+		CoinCount:           envInt("OCTAPOD_COIN_COUNT"),
+		KeyCount:            envInt("OCTAPOD_KEY_COUNT"),
+		LockCount:           envInt("OCTAPOD_LOCK_COUNT"),
+		OneWayCount:         envInt("OCTAPOD_ONE_WAY_COUNT"),
+		TeleporterCount:     envInt("OCTAPOD_TELEPORTER_COUNT"),
+		MudCount:            envInt("OCTAPOD_MUD_COUNT"),
+		MudCost:             envInt("OCTAPOD_MUD_COST"),
+		IceCount:            envInt("OCTAPOD_ICE_COUNT"),
+		TrapCount:           envInt("OCTAPOD_TRAP_COUNT"),
+		SolveRequiresKey:    envBool("OCTAPOD_SOLVE_REQUIRES_KEY"),
+		SolveMinCoins:       envInt("OCTAPOD_SOLVE_MIN_COINS"),
+		CoinScore:           envInt("OCTAPOD_COIN_SCORE"),
+		SolveScore:          envInt("OCTAPOD_SOLVE_SCORE"),
+		PathScore:           envInt("OCTAPOD_PATH_SCORE"),
+		SensorRangeFinder:   envBool("OCTAPOD_SENSOR_RANGE_FINDER"),
+		SensorCompass:       envBool("OCTAPOD_SENSOR_COMPASS"),
+		SensorSignal:        envBool("OCTAPOD_SENSOR_SIGNAL"),
+		SensorProximity:     envBool("OCTAPOD_SENSOR_PROXIMITY"),
+		ProximityRadius:     envInt("OCTAPOD_PROXIMITY_RADIUS"),
+		DiscordBotToken:     envString("DISCORD_BOT_TOKEN"),
+		DiscordChannelId:    envString("DISCORD_CHANNEL_ID"),
+	}
+}
+
+func envInt(key string) *int {
+	value, ok := lookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring %s, not a number: %s\n", key, value)
+		return nil
+	}
+	return &i
+}
+
+func envBool(key string) *bool {
+	value, ok := lookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring %s, not a boolean: %s\n", key, value)
+		return nil
+	}
+	return &b
+}
+
+func envString(key string) *string {
+	value, ok := lookupEnv(key)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+// lookupEnv treats an empty env var as unset, like a blank line in .env
+func lookupEnv(key string) (string, bool) {
+	value := os.Getenv(key)
+	return value, value != ""
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// ConnectionConfig sets how long octapods have to answer a ping, and drops the ones that went away
+// or stopped playing
+type ConnectionConfig struct {
+	// ResponseWindow rejects the moves arriving later than this after the ping, 0 accepts them
+	// until the next tick
+	ResponseWindow time.Duration
+	// IdleTimeout drops connections that sent nothing, heartbeat answers included
+	IdleTimeout time.Duration
+	// AfkTicks evicts octapods that sent no move for that many ticks, 0 never does
+	AfkTicks int
+}
+
+func (c *Config) connectionConfig() ConnectionConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ConnectionConfig{
+		ResponseWindow: time.Duration(c.ResponseWindow) * time.Millisecond,
+		IdleTimeout:    time.Duration(c.IdleTimeout) * time.Second,
+		AfkTicks:       c.AfkTicks,
+	}
+}
+
+// connectionConfig stops the idle clock while the lobby is paused, octapods only waiting for the next ping
+// would time out otherwise, TCP ones have no heartbeat to answer. The caller must hold the lobby lock.
+func (l *Lobby) connectionConfig() ConnectionConfig {
+	config := l.config.connectionConfig()
+	if l.paused {
+		config.IdleTimeout = 0
+	}
+	return config
+}
+
+// applyConnectionConfig updates octapods and spectators alike, the caller must hold the lobby lock
+func (l *Lobby) applyConnectionConfig() {
+	config := l.connectionConfig()
+	l.OctapodHandler.SetConnectionConfig(config)
+	l.Spectators.SetIdleTimeout(config.IdleTimeout)
+}
+
+// SetConnectionConfig applies the response window and the idle timeout to the connected octapods
+// and the ones joining later
+func (oh *OctapodHandler) SetConnectionConfig(config ConnectionConfig) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.connections = config
+	for _, octapod := range oh.octapods {
+		octapod.SetIdleTimeout(config.IdleTimeout)
+		octapod.SetResponseWindow(config.ResponseWindow)
+	}
+}
+
+// EvictIdle counts the tick just played for every octapod that didn't move and kicks the ones
+// idle for afkTicks, they are free to join again
+func (oh *OctapodHandler) EvictIdle(afkTicks int) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for id, octapod := range oh.octapods {
+		idle := octapod.CountIdleTick()
+		if afkTicks == 0 || idle < afkTicks {
+			continue
+		}
+
+		log.Printf("Evicting octapod %s, no move for %d ticks\n", id, idle)
+		delete(oh.octapods, id)
+		if err := octapod.SendKick("no move for "+strconv.Itoa(idle)+" ticks", false); err != nil {
+			log.Println("Error sending kick to", id, err)
+		}
+		octapod.Disconnect()
+	}
+}
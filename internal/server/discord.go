@@ -10,13 +10,13 @@ type DiscordBot struct {
 	channelId string
 }
 
-func NewDiscordBot(discordServer string, discordChannelId string) *DiscordBot {
+func NewDiscordBot(discordServer string, discordChannelId string) (*DiscordBot, error) {
 	token := discordServer
 
 	session, err := discordgo.New("Bot " + token)
 	log.Println("New Discord bot created")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
@@ -27,10 +27,10 @@ func NewDiscordBot(discordServer string, discordChannelId string) *DiscordBot {
 	}
 
 	if err = session.Open(); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return bot
+	return bot, nil
 }
 
 func (d *DiscordBot) Close() {
@@ -1,38 +1,63 @@
 package server
 
 import (
+	"errors"
 	"gbccsclub/octopod-challenge/internal/model"
 	"gbccsclub/octopod-challenge/pkg"
 	"github.com/google/uuid"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Lobby plays the rounds. The game only advances in Loop: ticks, steps, restarts and the fast mode all run
+// on its goroutine, so the state of a round has a single writer. The locks are there for the readers,
+// admin requests and connection goroutines, and are always taken in the same order, never backwards:
+//
+//	Lobby.mu, then OctapodHandler.mu or SpectatorHandler.mu, then Octapod.mu
+//
+// Config.mu is only held to copy the settings out, with nothing else locked after it. Writes to clients
+// are queued to a writer goroutine, so no lock is ever held across network IO.
 type Lobby struct {
-	mu         sync.Mutex
-	maze       *Maze
-	discordBot *DiscordBot
+	mu       sync.Mutex
+	maze     *Maze
+	notifier Notifier
 
 	ticker    *time.Ticker
 	done      chan struct{}
 	restart   chan struct{}
+	step      chan struct{}
 	stepCount int
+	// publishedAt is when the lobby was last sent to the notifier
+	publishedAt time.Time
 
 	config         *Config
 	AdminHandler   *AdminHandler
 	OctapodHandler *OctapodHandler
+	Spectators     *SpectatorHandler
 	stage          model.Status
+	paused         bool
+	sensors        SensorConfig
+	dynamics       DynamicsConfig
+	tickCount      int
+	tiles          TileConfig
+	objectives     Objectives
+
+	round  *round
+	rounds []RoundSummary
 }
 
-func NewLobby(config *Config) *Lobby {
+func NewLobby(config *Config, store *ConfigStore) *Lobby {
 	return &Lobby{
 		stepCount:      0,
 		config:         config,
 		restart:        make(chan struct{}, 1),
-		AdminHandler:   NewAdminHandler(config),
+		step:           make(chan struct{}, 1),
+		AdminHandler:   NewAdminHandler(config, store),
 		OctapodHandler: NewOctapodHandler(),
+		Spectators:     NewSpectatorHandler(),
 		stage:          model.Exploring,
 	}
 }
@@ -46,13 +71,30 @@ func (l *Lobby) setupLobbyFromConfig() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	config := l.config.Export()
+
 	l.stage = model.Exploring
 	l.stepCount = 0
-	l.ticker = time.NewTicker(time.Duration(l.config.TickInterval) * time.Millisecond)
-	l.maze = NewMaze(l.config.MazeSize, l.config.MazeSize)
+	l.ticker = time.NewTicker(time.Duration(config.TickInterval) * time.Millisecond)
+	l.maze = NewMaze(config.MazeSize, config.MazeSize, config.Floors, config.Topology)
 	l.maze.Generate()
-	l.discordBot = NewDiscordBot(l.config.DiscordBotToken, l.config.DiscordChannelId)
+	l.maze.PlaceStairs(config.StairsPerFloor)
+	l.dynamics = l.config.dynamicsConfig()
+	l.maze.PlaceDoors(l.dynamics.DoorCount, l.dynamics.DoorPeriod)
+	l.tickCount = 0
+	l.tiles = l.config.tileConfig()
+	l.objectives = l.config.objectives()
+	l.sensors = l.config.sensorConfig()
+	if l.notifier == nil {
+		l.config.mu.RLock()
+		token, channelId := l.config.DiscordBotToken, l.config.DiscordChannelId
+		l.config.mu.RUnlock()
+		l.notifier = NewNotifier(token, channelId)
+	}
 	l.done = make(chan struct{})
+
+	l.applyConnectionConfig()
+	l.startRound()
 }
 
 func (l *Lobby) Loop() {
@@ -64,18 +106,39 @@ func (l *Lobby) Loop() {
 			return
 		case <-l.restart:
 			l.handleRestart()
+		case <-l.step:
+			l.handleStep()
+		case <-l.OctapodHandler.Moved():
+			l.handleMoved()
 		}
 	}
 }
 
 func (l *Lobby) handleRestart() {
 	l.ticker.Stop()
+	l.mu.Lock()
+	l.endRound(RoundRestarted)
+	l.mu.Unlock()
 	l.setupLobbyFromConfig()
 }
 
+// handleMoved plays the tick early in fast mode, the interval restarts as the timeout of the next one
+func (l *Lobby) handleMoved() {
+	if !l.config.fastMode() || l.IsPaused() || !l.OctapodHandler.AllMoved() {
+		return
+	}
+	l.tick()
+	l.ticker.Reset(time.Duration(l.config.Export().TickInterval) * time.Millisecond)
+}
+
 func (l *Lobby) Stop() {
-	close(l.done)
-	l.ticker.Stop()
+	// A restart in Loop replaces both
+	l.mu.Lock()
+	done, ticker := l.done, l.ticker
+	l.mu.Unlock()
+
+	close(done)
+	ticker.Stop()
 }
 
 func (l *Lobby) Restart() {
@@ -83,6 +146,56 @@ func (l *Lobby) Restart() {
 	l.Start()
 }
 
+// ApplyConfig validates and applies a config update.
+// Tick interval and notifier changes are applied live, maze changes start a new round.
+func (l *Lobby) ApplyConfig(update ConfigUpdate) (ConfigChanges, error) {
+	changes, err := l.config.Apply(update)
+	if err != nil {
+		return ConfigChanges{}, err
+	}
+
+	if changes.Round {
+		// The new round creates its own ticker
+		l.RequestRestart()
+		l.applyLiveConfig(ConfigChanges{Notifier: changes.Notifier, Connections: changes.Connections, Rules: changes.Rules})
+	} else {
+		l.applyLiveConfig(changes)
+	}
+	return changes, nil
+}
+
+func (l *Lobby) applyLiveConfig(changes ConfigChanges) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config := l.config.Export()
+
+	if changes.Ticker && l.ticker != nil {
+		log.Println("Tick interval changed to", config.TickInterval)
+		l.ticker.Reset(time.Duration(config.TickInterval) * time.Millisecond)
+	}
+
+	if changes.Connections {
+		l.applyConnectionConfig()
+	}
+
+	// Octapods joining mid-round are welcomed with the rules in play
+	if (changes.Ticker || changes.Rules) && l.round != nil {
+		l.OctapodHandler.SetRound(l.roundInfo())
+	}
+
+	if changes.Notifier {
+		l.config.mu.RLock()
+		token, channelId := l.config.DiscordBotToken, l.config.DiscordChannelId
+		l.config.mu.RUnlock()
+
+		if l.notifier != nil {
+			l.notifier.Close()
+		}
+		l.notifier = NewNotifier(token, channelId)
+	}
+}
+
 func (l *Lobby) RequestRestart() {
 	log.Println("Restart requested")
 	select {
@@ -91,21 +204,162 @@ func (l *Lobby) RequestRestart() {
 	}
 }
 
+// Pause freezes the round, ticks are skipped until Resume is called.
+// Octapods keep their pending moves and are told the round is paused.
+func (l *Lobby) Pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	log.Println("Lobby paused")
+	l.paused = true
+	l.applyConnectionConfig()
+	l.OctapodHandler.NotifyAll(model.Paused)
+}
+
+func (l *Lobby) Resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	log.Println("Lobby resumed")
+	l.paused = false
+	l.applyConnectionConfig()
+	l.OctapodHandler.NotifyAll(l.stage)
+}
+
+// Step advances a paused lobby by a single tick
+func (l *Lobby) Step() error {
+	if !l.IsPaused() {
+		return errors.New("lobby must be paused to step")
+	}
+
+	select {
+	case l.step <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (l *Lobby) handleStep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.paused {
+		return
+	}
+	log.Println("Stepping paused lobby")
+	l.advance()
+	l.OctapodHandler.NotifyAll(model.Paused)
+	l.spectate()
+}
+
+// LobbyStatus is the admin view of the round in progress
+type LobbyStatus struct {
+	Round     int          `json:"round"`
+	Stage     model.Status `json:"stage"`
+	StepCount int          `json:"stepCount"`
+	Paused    bool         `json:"paused"`
+	Octapods  int          `json:"octapods"`
+	// Spectators are watching the lobby, they are not octapods
+	Spectators int `json:"spectators"`
+}
+
+func (l *Lobby) GetStatus() LobbyStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LobbyStatus{
+		Round:      l.round.number,
+		Stage:      l.stage,
+		StepCount:  l.stepCount,
+		Paused:     l.paused,
+		Octapods:   l.OctapodHandler.GetOctapodCount(),
+		Spectators: l.Spectators.GetSpectatorCount(),
+	}
+}
+
+func (l *Lobby) IsPaused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paused
+}
+
+// GetRounds returns the finished rounds, oldest first
+func (l *Lobby) GetRounds() []RoundSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rounds := make([]RoundSummary, len(l.rounds))
+	copy(rounds, l.rounds)
+	return rounds
+}
+
+func (l *Lobby) startRound() {
+	number := 1
+	if l.round != nil {
+		number = l.round.number + 1
+	}
+	l.round = newRound(number, l.maze.Width)
+	if err := l.maze.PlaceTiles(l.tiles); err != nil {
+		log.Printf("Round %d cannot be solved: %v\n", number, err)
+	}
+	l.round.optimalCost = l.maze.ShortestPathCost()
+	l.OctapodHandler.ResetAll(pkg.ZeroVec2())
+	l.OctapodHandler.StartRound(l.roundInfo())
+}
+
+// roundInfo describes the round to the octapods, the config lock must not be held
+func (l *Lobby) roundInfo() model.RoundInfo {
+	config := l.config.Export()
+	return model.RoundInfo{
+		Round:               l.round.number,
+		Width:               l.maze.Width,
+		Height:              l.maze.Height,
+		Floors:              l.maze.Floors,
+		Topology:            l.maze.Grid().Topology,
+		TickInterval:        config.TickInterval,
+		MaxExplorationSteps: config.MaxExplorationSteps,
+		MaxSolvingSteps:     config.MaxSolvingSteps,
+		Rules:               l.config.actionRules(),
+	}
+}
+
+func (l *Lobby) endRound(outcome RoundOutcome) {
+	if l.round == nil {
+		return
+	}
+	coverage := l.OctapodHandler.GetCoverage(l.maze.CountOpen())
+	scores := l.OctapodHandler.GetScores(l.objectives, l.round.solved, l.round.optimalCost)
+	latency := l.OctapodHandler.GetLatencies()
+	summary := l.round.summarize(outcome, coverage, scores, latency)
+	l.OctapodHandler.EndRound(summary, l.round.solved)
+	l.rounds = append(l.rounds, summary)
+	if len(l.rounds) > maxRoundHistory {
+		l.rounds = l.rounds[len(l.rounds)-maxRoundHistory:]
+	}
+}
+
 func (l *Lobby) renderMazeAscii() string {
 	octapodPositions := l.OctapodHandler.GetOctapodPositionSet()
+	topology := l.maze.Grid().Topology
+
+	// A torus has no outer wall, hex rows lean right by half a cell each
+	border := 1
+	if topology == pkg.Torus {
+		border = 0
+	}
+
+	// Floors are drawn side by side, ground floor first
+	rows := l.maze.Height + 2*border
 	view := ""
-	for y := -1; y <= l.maze.Height; y++ {
-		for x := -1; x <= l.maze.Width; x++ {
-			pos := pkg.Vec2(x, y)
-
-			if x == l.maze.Width-1 && y == l.maze.Height-1 {
-				view += "* "
-			} else if octId, ok := octapodPositions[pos]; ok {
-				view += octId[0:1] + " "
-			} else if l.maze.IsAvailable(pos) {
+	for y := -border; y < l.maze.Height+border; y++ {
+		for z := 0; z < l.maze.Floors; z++ {
+			if z > 0 {
 				view += "  "
-			} else {
-				view += "▦ "
+			}
+			if topology == pkg.Hex {
+				view += strings.Repeat(" ", y+border)
+			}
+			for x := -border; x < l.maze.Width+border; x++ {
+				view += l.renderCell(pkg.Vec3(x, y, z), octapodPositions, topology) + " "
+			}
+			if topology == pkg.Hex {
+				view += strings.Repeat(" ", rows-1-(y+border))
 			}
 		}
 
@@ -114,41 +368,189 @@ func (l *Lobby) renderMazeAscii() string {
 	return view
 }
 
+func (l *Lobby) renderCell(pos pkg.Vector, octapodPositions map[pkg.Vector][]string, topology pkg.Topology) string {
+	isDoor, isOpen := l.maze.IsDoor(pos)
+	tile, isTile := l.maze.GetTile(pos)
+	up, down := l.maze.StairsAt(pos)
+
+	if l.maze.IsSolved(pos) {
+		return "*"
+	} else if octIds, ok := octapodPositions[pos]; ok {
+		return renderOctapods(octIds)
+	} else if isTile {
+		return renderTile(tile, topology)
+	} else if up && down {
+		return "◆"
+	} else if up {
+		return "▲"
+	} else if down {
+		return "▼"
+	} else if isDoor && isOpen {
+		return "░"
+	} else if isDoor {
+		return "▥"
+	} else if l.maze.IsAvailable(pos) {
+		return " "
+	} else {
+		return "▦"
+	}
+}
+
+// renderOctapods draws a single octapod by its initial and a stack by its size
+func renderOctapods(ids []string) string {
+	if len(ids) == 1 {
+		return ids[0][0:1]
+	}
+	if len(ids) > 9 {
+		return "+"
+	}
+	return strconv.Itoa(len(ids))
+}
+
+func renderTile(tile pkg.Tile, topology pkg.Topology) string {
+	switch tile.Kind {
+	case pkg.Coin:
+		return "$"
+	case pkg.Key:
+		return "⚷"
+	case pkg.Lock:
+		return "▣"
+	case pkg.Teleporter:
+		return "◎"
+	case pkg.Mud:
+		return "~"
+	case pkg.Ice:
+		return "≡"
+	case pkg.Trap:
+		return "×"
+	case pkg.OneWay:
+		if topology == pkg.Hex && *tile.Direction == pkg.Vec2Up() {
+			return "↖"
+		}
+		if topology == pkg.Hex && *tile.Direction == pkg.Vec2Down() {
+			return "↘"
+		}
+		switch *tile.Direction {
+		case pkg.Vec2Up():
+			return "↑"
+		case pkg.Vec2Down():
+			return "↓"
+		case pkg.Vec2Left():
+			return "←"
+		case pkg.Vec2Right():
+			return "→"
+		case pkg.Vec2(1, -1):
+			return "↗"
+		default:
+			return "↙"
+		}
+	default:
+		return "?"
+	}
+}
+
 func (l *Lobby) tick() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if l.OctapodHandler.GetOctapodCount() == 0 {
+	if l.paused {
+		// Keep reminding octapods, including the ones that joined during the pause
+		l.OctapodHandler.NotifyAll(model.Paused)
+	} else {
+		l.advance()
+	}
+	l.spectate()
+}
+
+// advance plays a single tick, the caller must hold the lock
+func (l *Lobby) advance() {
+	octapodCount := l.OctapodHandler.GetOctapodCount()
+	if octapodCount == 0 {
 		return
 	}
+	l.round.peakOctapods = max(l.round.peakOctapods, octapodCount)
 
 	// Update octapods
-	solvedOctapods := l.OctapodHandler.UpdateAll(l.maze)
-
-	// Render maze
-	view := l.renderStats(solvedOctapods)
-	view += "```" + l.renderMazeAscii() + "```"
-	//for _, v := range splitByNewline(view) {
-	//	l.discordBot.SendMessage(v)
-	//}
-	l.discordBot.SendMessage(view)
+	solvedOctapods := l.OctapodHandler.UpdateAll(l.maze, l.config.actionRules(), l.config.collisionMode(), l.objectives)
+	if l.stage == model.Solving {
+		for _, octapod := range solvedOctapods {
+			if _, ok := l.round.solved[octapod.GetId()]; !ok {
+				l.round.solved[octapod.GetId()] = l.stepCount + 1
+			}
+		}
+	}
 
-	// Ping octapods
-	tickId := uuid.New().String()
-	l.OctapodHandler.PingAll(tickId, model.Exploring, l.maze)
+	// Render maze, fast ticks are published at most once per interval so Discord keeps up
+	config := l.config.Export()
+	interval := time.Duration(config.TickInterval) * time.Millisecond
+	if !isSilent(l.notifier) && (!config.FastMode || time.Since(l.publishedAt) >= interval) {
+		view := l.renderStats(solvedOctapods)
+		view += "```" + l.renderMazeAscii() + "```"
+		//for _, v := range splitByNewline(view) {
+		//	l.notifier.SendMessage(v)
+		//}
+		l.notifier.SendMessage(view)
+		l.publishedAt = time.Now()
+	}
 
 	// Update step count
 	l.updateStep()
+
+	// Move doors and walls, the next moves are checked against the new layout
+	l.tickCount++
+	l.maze.Advance(l.tickCount, l.dynamics, l.OctapodHandler.GetOctapodPositionSet())
+
+	// Drop the octapods that stopped playing before the next tick
+	l.OctapodHandler.EvictIdle(l.config.connectionConfig().AfkTicks)
+
+	// Ping octapods with the stage of the next tick
+	tickId := uuid.New().String()
+	l.OctapodHandler.PingAll(tickId, l.stage, l.maze, l.sensors, l.config.teamConfig(), l.objectives)
+}
+
+// spectate sends the state of the lobby to the spectators, it is not even built when nobody watches
+func (l *Lobby) spectate() {
+	if !l.Spectators.IsWatched() {
+		return
+	}
+
+	scores := l.OctapodHandler.GetScores(l.objectives, l.round.solved, l.round.optimalCost)
+	octapods := l.OctapodHandler.ListOctapods()
+	spectated := make([]SpectatedOctapod, 0, len(octapods))
+	for _, octapod := range octapods {
+		spectated = append(spectated, SpectatedOctapod{
+			Id:       octapod.Id,
+			Team:     octapod.Team,
+			Position: octapod.Position,
+			Score:    scores[octapod.Id],
+		})
+	}
+
+	l.Spectators.Broadcast(SpectatorState{
+		Round:    l.round.number,
+		Tick:     l.tickCount,
+		Stage:    l.stage,
+		Step:     l.stepCount,
+		Paused:   l.paused,
+		Maze:     l.maze.Snapshot(),
+		Octapods: spectated,
+		Solved:   l.round.solved,
+	})
 }
 
 func (l *Lobby) updateStep() {
+	config := l.config.Export()
 	l.stepCount++
-	if l.stage == model.Exploring && l.stepCount >= l.config.MaxExplorationSteps {
+	if l.stage == model.Exploring && l.stepCount >= config.MaxExplorationSteps {
 		l.stage = model.Solving
 		l.stepCount = 0
-	} else if l.stage == model.Solving && l.stepCount >= l.config.MaxSolvingSteps {
+		// Everybody solves from the start, so the solve time measures the path taken
+		l.OctapodHandler.RespawnAll(pkg.ZeroVec2())
+	} else if l.stage == model.Solving && l.stepCount >= config.MaxSolvingSteps {
 		l.stage = model.Ended
 		l.stepCount = 0
+		l.endRound(RoundCompleted)
+		l.startRound()
 	} else if l.stage == model.Ended {
 		l.stage = model.Exploring
 		l.stepCount = 0
@@ -156,14 +558,15 @@ func (l *Lobby) updateStep() {
 }
 
 func (l *Lobby) renderStats(solvedOctapods []*model.Octapod) string {
+	config := l.config.Export()
 	view := ""
 
 	view += "Stage: " + l.stage.String() + "\n"
 
 	if l.stage == model.Exploring {
-		view += "Step: " + strconv.Itoa(l.stepCount) + "/" + strconv.Itoa(l.config.MaxExplorationSteps) + "\n"
+		view += "Step: " + strconv.Itoa(l.stepCount) + "/" + strconv.Itoa(config.MaxExplorationSteps) + "\n"
 	} else if l.stage == model.Solving {
-		view += "Step: " + strconv.Itoa(l.stepCount) + "/" + strconv.Itoa(l.config.MaxSolvingSteps) + "\n"
+		view += "Step: " + strconv.Itoa(l.stepCount) + "/" + strconv.Itoa(config.MaxSolvingSteps) + "\n"
 	}
 
 	view += "Octapods: " + strconv.Itoa(l.OctapodHandler.GetOctapodCount()) + "\n"
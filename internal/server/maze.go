@@ -7,52 +7,89 @@ import (
 )
 
 type Maze struct {
-	Width   int
-	Height  int
-	cells   [][]bool // true: wall, false: path
-	visited [][]bool
+	Width  int
+	Height int
+	Floors int
+	cells  [][][]bool // [z][x][y], true: wall, false: path
+
+	// See mazeTopology.go
+	grid     pkg.Grid
+	exitCell pkg.Vector
+
+	// See mazeDynamics.go
+	tick  int
+	doors map[pkg.Vector]Door
+
+	// See tiles.go
+	tiles      map[pkg.Vector]pkg.Tile
+	requireKey bool
+
+	// See mazeLevels.go
+	stairs map[pkg.Vector]bool
 }
 
-func NewMaze(width, height int) *Maze {
+func NewMaze(width, height, floors int, topology pkg.Topology) *Maze {
+	if topology == pkg.Torus {
+		// The passages only meet across the edges when the sizes are even
+		width += width % 2
+		height += height % 2
+	}
+
 	m := &Maze{
-		Width:   width,
-		Height:  height,
-		cells:   make([][]bool, width),
-		visited: make([][]bool, width),
+		Width:    width,
+		Height:   height,
+		Floors:   floors,
+		grid:     pkg.Grid{Topology: topology, Width: width, Height: height, Floors: floors},
+		exitCell: pkg.Vec3(width-1, height-1, floors-1),
+		cells:    make([][][]bool, floors),
+		doors:    make(map[pkg.Vector]Door),
+		tiles:    make(map[pkg.Vector]pkg.Tile),
+		stairs:   make(map[pkg.Vector]bool),
 	}
-	for i := range m.cells {
-		m.cells[i] = make([]bool, height)
-		m.visited[i] = make([]bool, height)
+	for z := range m.cells {
+		m.cells[z] = make([][]bool, width)
+		for i := range m.cells[z] {
+			m.cells[z][i] = make([]bool, height)
+		}
 	}
 	return m
 }
 
 // Generate creates a maze with walls (true) and passages (false)
-// Start is at (0,0) and end is at (width-1,height-1)
+// Start is at (0,0) and end is at (width-1,height-1) on the top floor
 func (m *Maze) Generate() {
+	for z := 0; z < m.Floors; z++ {
+		if m.grid.Topology != pkg.Square {
+			m.generateGrowingTree(z)
+		} else {
+			m.generateFloor(z)
+		}
+	}
+}
+
+func (m *Maze) generateFloor(z int) {
 	// First, fill the entire maze with walls
 	for x := 0; x < m.Width; x++ {
 		for y := 0; y < m.Height; y++ {
-			m.cells[x][y] = true
-			m.visited[x][y] = false
+			m.cells[z][x][y] = true
 		}
 	}
 
 	// Use depth-first search with backtracking to create paths
 	// Start from (1,1) in cell coordinates
-	m.carvePassages(1, 1)
+	m.carvePassages(z, 1, 1)
 
 	// Create entrance (top-left) and exit (bottom-right)
-	m.cells[0][0] = false
-	m.cells[1][0] = false
-	m.cells[m.Width-1][m.Height-1] = false
-	m.cells[m.Width-2][m.Height-1] = false
+	m.cells[z][0][0] = false
+	m.cells[z][1][0] = false
+	m.cells[z][m.Width-1][m.Height-1] = false
+	m.cells[z][m.Width-2][m.Height-1] = false
 }
 
 // carvePassages uses depth-first search with backtracking to carve passages
-func (m *Maze) carvePassages(x, y int) {
+func (m *Maze) carvePassages(z, x, y int) {
 	// Mark the current cell as a passage
-	m.cells[x][y] = false
+	m.cells[z][x][y] = false
 
 	// Define the four possible directions: N, E, S, W
 	directions := []struct{ dx, dy int }{
@@ -72,12 +109,12 @@ func (m *Maze) carvePassages(x, y int) {
 		newX, newY := x+dir.dx, y+dir.dy
 
 		// Check if the new position is within bounds and unvisited (still a wall)
-		if newX >= 0 && newX < m.Width && newY >= 0 && newY < m.Height && m.cells[newX][newY] {
+		if newX >= 0 && newX < m.Width && newY >= 0 && newY < m.Height && m.cells[z][newX][newY] {
 			// Carve a passage by removing the wall between current cell and new cell
-			m.cells[x+dir.dx/2][y+dir.dy/2] = false
+			m.cells[z][x+dir.dx/2][y+dir.dy/2] = false
 
 			// Continue DFS from the new cell
-			m.carvePassages(newX, newY)
+			m.carvePassages(z, newX, newY)
 		}
 	}
 }
@@ -87,26 +124,44 @@ func (m *Maze) carvePassages(x, y int) {
 // And there's no comments. No comments = Human.
 
 func (m *Maze) IsAvailable(point pkg.Vector) bool {
-	x := point.X
-	y := point.Y
-	return x >= 0 && x < m.Width && y >= 0 && y < m.Height && !m.cells[x][y]
+	return m.isPassage(point) && !m.isDoorClosed(point)
 }
 
 // GetSensor returns a sensor for the given point
 // True means there is a wall
 func (m *Maze) GetSensor(point pkg.Vector) *pkg.Sensor {
-	return &pkg.Sensor{
-		Up:    !m.IsAvailable(point.Up()),
-		Down:  !m.IsAvailable(point.Down()),
-		Right: !m.IsAvailable(point.Right()),
-		Left:  !m.IsAvailable(point.Left()),
+	if m.grid.Topology == pkg.Hex {
+		return m.getHexSensor(point)
+	}
+
+	sensor := &pkg.Sensor{
+		Up:    m.isBlocked(point, pkg.Vec2Up()),
+		Down:  m.isBlocked(point, pkg.Vec2Down()),
+		Right: m.isBlocked(point, pkg.Vec2Right()),
+		Left:  m.isBlocked(point, pkg.Vec2Left()),
+	}
+	if m.grid.Topology == pkg.Torus {
+		sensor.Topology = pkg.Torus
 	}
+	m.readStairs(point, sensor)
+	return sensor
 }
 
-func (m *Maze) Visit(position pkg.Vector) {
-	m.visited[position.X][position.Y] = true
+// CountOpen returns the number of cells that are not walls
+func (m *Maze) CountOpen() int {
+	count := 0
+	for z := 0; z < m.Floors; z++ {
+		for x := 0; x < m.Width; x++ {
+			for y := 0; y < m.Height; y++ {
+				if !m.cells[z][x][y] {
+					count++
+				}
+			}
+		}
+	}
+	return count
 }
 
 func (m *Maze) IsSolved(position pkg.Vector) bool {
-	return position.X == m.Width-1 && position.Y == m.Height-1
+	return position == m.exitCell
 }
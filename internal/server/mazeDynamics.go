@@ -0,0 +1,167 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+	"math/rand"
+)
+
+// shiftAttempts bounds the random tries to find a wall shift that keeps the maze solvable
+const shiftAttempts = 20
+
+// DynamicsConfig makes the maze change over the round
+type DynamicsConfig struct {
+	DoorCount  int
+	DoorPeriod int
+	// ShiftInterval is the number of ticks between wall shifts, 0 keeps walls in place
+	ShiftInterval int
+	ShiftCount    int
+}
+
+func (c *Config) dynamicsConfig() DynamicsConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return DynamicsConfig{
+		DoorCount:     c.DoorCount,
+		DoorPeriod:    c.DoorPeriod,
+		ShiftInterval: c.WallShiftInterval,
+		ShiftCount:    c.WallShiftCount,
+	}
+}
+
+// Door is a passage cell that is open and closed in turns, Period ticks each
+type Door struct {
+	Position pkg.Vector
+	Period   int
+	Offset   int
+}
+
+func (d Door) IsOpen(tick int) bool {
+	return ((tick+d.Offset)/d.Period)%2 == 0
+}
+
+// PlaceDoors turns random passage cells, away from the start and exit, into doors
+func (m *Maze) PlaceDoors(count int, period int) {
+	m.doors = make(map[pkg.Vector]Door)
+
+	candidates := m.passages()
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for _, position := range candidates[:min(count, len(candidates))] {
+		m.doors[position] = Door{
+			Position: position,
+			Period:   period,
+			Offset:   rand.Intn(2 * period),
+		}
+	}
+}
+
+// Advance moves the maze to the tick, shifting walls when due.
+// Occupied cells are never turned into walls.
+func (m *Maze) Advance(tick int, dynamics DynamicsConfig, occupied map[pkg.Vector][]string) {
+	m.tick = tick
+
+	if dynamics.ShiftInterval > 0 && tick > 0 && tick%dynamics.ShiftInterval == 0 {
+		for i := 0; i < dynamics.ShiftCount; i++ {
+			m.shiftWall(occupied)
+		}
+	}
+}
+
+func (m *Maze) isDoorClosed(point pkg.Vector) bool {
+	door, ok := m.doors[point]
+	return ok && !door.IsOpen(m.tick)
+}
+
+// IsDoor reports whether the point is a door and whether it is open at the current tick
+func (m *Maze) IsDoor(point pkg.Vector) (isDoor bool, isOpen bool) {
+	door, ok := m.doors[point]
+	return ok, ok && door.IsOpen(m.tick)
+}
+
+// shiftWall opens a random wall and closes a random passage,
+// as long as the exit stays reachable from the start and from every octapod
+func (m *Maze) shiftWall(occupied map[pkg.Vector][]string) {
+	for attempt := 0; attempt < shiftAttempts; attempt++ {
+		z := rand.Intn(m.Floors)
+		opened := pkg.Vec3(rand.Intn(m.Width), rand.Intn(m.Height), z)
+		closed := pkg.Vec3(rand.Intn(m.Width), rand.Intn(m.Height), z)
+
+		if !m.isWall(opened) || m.isWall(closed) || !m.canClose(closed, occupied) {
+			continue
+		}
+
+		m.setWall(opened, false)
+		m.setWall(closed, true)
+		if m.isSolvable() && m.canEscape(occupied) {
+			return
+		}
+
+		m.setWall(opened, true)
+		m.setWall(closed, false)
+	}
+}
+
+func (m *Maze) canClose(point pkg.Vector, occupied map[pkg.Vector][]string) bool {
+	if point == pkg.ZeroVec2() || m.IsSolved(point) {
+		return false
+	}
+	if _, ok := m.doors[point]; ok {
+		return false
+	}
+	if _, ok := m.tiles[point]; ok {
+		return false
+	}
+	if m.isStairs(point) {
+		return false
+	}
+	_, ok := occupied[point]
+	return !ok
+}
+
+// isSolvable checks the exit can be reached from the start,
+// picking up a key on the way when there are locked doors
+func (m *Maze) isSolvable() bool {
+	if !m.reachable(true)[m.exitCell] {
+		return false
+	}
+
+	locked, keyFound := false, false
+	withoutKey := m.reachable(false)
+	for position, tile := range m.tiles {
+		locked = locked || tile.Kind == pkg.Lock
+		keyFound = keyFound || tile.Kind == pkg.Key && withoutKey[position]
+	}
+	return !locked && !m.requireKey || keyFound
+}
+
+// canEscape checks no octapod is sealed away from the exit
+func (m *Maze) canEscape(occupied map[pkg.Vector][]string) bool {
+	for position := range occupied {
+		if !m.reachableFrom(position, true)[m.exitCell] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Maze) isPassage(point pkg.Vector) bool {
+	return m.grid.Contains(point) && !m.isWall(point)
+}
+
+// passages lists the open cells other than the start, the exit and the stairs
+func (m *Maze) passages() []pkg.Vector {
+	passages := make([]pkg.Vector, 0)
+	for z := 0; z < m.Floors; z++ {
+		for x := 0; x < m.Width; x++ {
+			for y := 0; y < m.Height; y++ {
+				point := pkg.Vec3(x, y, z)
+				if !m.isWall(point) && point != pkg.ZeroVec2() && !m.IsSolved(point) && !m.isStairs(point) {
+					passages = append(passages, point)
+				}
+			}
+		}
+	}
+	return passages
+}
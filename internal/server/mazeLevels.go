@@ -0,0 +1,83 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+	"math/rand"
+)
+
+func (m *Maze) isWall(point pkg.Vector) bool {
+	return m.cells[point.Z][point.X][point.Y]
+}
+
+func (m *Maze) setWall(point pkg.Vector, wall bool) {
+	m.cells[point.Z][point.X][point.Y] = wall
+}
+
+// directions lists the steps to every neighbour, up and down the stairs included
+func (m *Maze) directions() []pkg.Vector {
+	directions := m.grid.Topology.Directions()
+	if m.Floors > 1 {
+		directions = append(directions, pkg.Vec3(0, 0, 1), pkg.Vec3(0, 0, -1))
+	}
+	return directions
+}
+
+// PlaceStairs joins every floor to the one above with stairs on random cells open on both.
+// Every floor is a connected maze, so a single flight keeps the exit reachable.
+func (m *Maze) PlaceStairs(perFloor int) {
+	m.stairs = make(map[pkg.Vector]bool)
+
+	for z := 0; z < m.Floors-1; z++ {
+		candidates := make([]pkg.Vector, 0)
+		for x := 0; x < m.Width; x++ {
+			for y := 0; y < m.Height; y++ {
+				lower := pkg.Vec3(x, y, z)
+				if m.isWall(lower) || m.isWall(lower.Above()) || m.IsSolved(lower.Above()) || lower == pkg.ZeroVec2() {
+					continue
+				}
+				candidates = append(candidates, lower)
+			}
+		}
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+
+		for _, lower := range candidates[:min(max(perFloor, 1), len(candidates))] {
+			m.stairs[lower] = true
+		}
+	}
+}
+
+// hasStairs reports whether stairs join the two cells, one floor apart
+func (m *Maze) hasStairs(from pkg.Vector, to pkg.Vector) bool {
+	if from.X != to.X || from.Y != to.Y {
+		return false
+	}
+	switch to.Z - from.Z {
+	case 1:
+		return m.stairs[from]
+	case -1:
+		return m.stairs[to]
+	default:
+		return false
+	}
+}
+
+func (m *Maze) isStairs(point pkg.Vector) bool {
+	return m.stairs[point] || m.stairs[point.Below()]
+}
+
+// StairsAt reports whether the cell has stairs going up and going down
+func (m *Maze) StairsAt(point pkg.Vector) (up bool, down bool) {
+	return m.stairs[point], m.stairs[point.Below()]
+}
+
+func (m *Maze) readStairs(point pkg.Vector, sensor *pkg.Sensor) {
+	if m.Floors <= 1 {
+		return
+	}
+	up, down := m.StairsAt(point)
+	ascend, descend := !up, !down
+	sensor.Ascend = &ascend
+	sensor.Descend = &descend
+}
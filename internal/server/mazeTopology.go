@@ -0,0 +1,117 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+	"math/rand"
+)
+
+// growingTreeBranching is how often the growing tree carves from a random cell instead of the newest,
+// higher values give more branches and shorter corridors
+const growingTreeBranching = 0.25
+
+func (m *Maze) Grid() pkg.Grid {
+	return m.grid
+}
+
+// generateGrowingTree carves a floor over the cells with even coordinates, which works for any topology.
+// The cells in between are the walls knocked down to join two neighbours.
+func (m *Maze) generateGrowingTree(z int) {
+	for x := 0; x < m.Width; x++ {
+		for y := 0; y < m.Height; y++ {
+			m.cells[z][x][y] = true
+		}
+	}
+
+	directions := m.grid.Topology.Directions()
+	start := pkg.Vec3(0, 0, z)
+	m.cells[z][start.X][start.Y] = false
+	active := []pkg.Vector{start}
+
+	for len(active) > 0 {
+		index := len(active) - 1
+		if rand.Float64() < growingTreeBranching {
+			index = rand.Intn(len(active))
+		}
+		current := active[index]
+
+		rand.Shuffle(len(directions), func(i, j int) {
+			directions[i], directions[j] = directions[j], directions[i]
+		})
+
+		carved := false
+		for _, direction := range directions {
+			wall, okWall := m.grid.Step(current, direction)
+			next, okNext := m.grid.Step(wall, direction)
+			if !okWall || !okNext || !m.cells[z][next.X][next.Y] {
+				continue
+			}
+			m.cells[z][wall.X][wall.Y] = false
+			m.cells[z][next.X][next.Y] = false
+			active = append(active, next)
+			carved = true
+			break
+		}
+
+		if !carved {
+			active = append(active[:index], active[index+1:]...)
+		}
+	}
+
+	m.exitCell = m.farthestCell()
+}
+
+// farthestCell picks the exit on the top floor, the far corner of a hex grid or the middle of a torus
+func (m *Maze) farthestCell() pkg.Vector {
+	x, y := m.Width-1, m.Height-1
+	if m.grid.Topology == pkg.Torus {
+		x, y = m.Width/2, m.Height/2
+	}
+	return pkg.Vec3(x-x%2, y-y%2, m.Floors-1)
+}
+
+// step returns the neighbour in the direction, or the point off the grid when there is none
+func (m *Maze) step(point pkg.Vector, direction pkg.Vector) pkg.Vector {
+	next, _ := m.grid.Step(point, direction)
+	return next
+}
+
+func (m *Maze) isBlocked(point pkg.Vector, direction pkg.Vector) bool {
+	return !m.IsAvailable(m.step(point, direction))
+}
+
+func (m *Maze) getHexSensor(point pkg.Vector) *pkg.Sensor {
+	upLeft := m.isBlocked(point, pkg.Vec2(0, -1))
+	upRight := m.isBlocked(point, pkg.Vec2(1, -1))
+	downLeft := m.isBlocked(point, pkg.Vec2(-1, 1))
+	downRight := m.isBlocked(point, pkg.Vec2(0, 1))
+
+	sensor := &pkg.Sensor{
+		Left:      m.isBlocked(point, pkg.Vec2Left()),
+		Right:     m.isBlocked(point, pkg.Vec2Right()),
+		Up:        true,
+		Down:      true,
+		Topology:  pkg.Hex,
+		UpLeft:    &upLeft,
+		UpRight:   &upRight,
+		DownLeft:  &downLeft,
+		DownRight: &downRight,
+	}
+	m.readStairs(point, sensor)
+	return sensor
+}
+
+// Surroundings returns the point and its neighbours, as the octapod sees them
+func (m *Maze) Surroundings(point pkg.Vector) []pkg.Cell {
+	cells := []pkg.Cell{{Vector: point, Wall: false, Tile: m.tiles[point].Kind}}
+	for _, direction := range m.grid.Topology.Directions() {
+		next := m.step(point, direction)
+		cells = append(cells, pkg.Cell{Vector: next, Wall: !m.IsAvailable(next), Tile: m.tiles[next].Kind})
+	}
+	// Only the floors the stairs lead to are in sight
+	for _, next := range []pkg.Vector{point.Above(), point.Below()} {
+		if m.hasStairs(point, next) {
+			cells = append(cells, pkg.Cell{Vector: next, Wall: !m.IsAvailable(next), Tile: m.tiles[next].Kind})
+		}
+	}
+	return cells
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"log"
+)
+
+// Notifier publishes the rendered lobby state after every tick
+type Notifier interface {
+	SendMessage(message string)
+	Close()
+}
+
+// NewNotifier connects the Discord bot. Without a token, or when the bot
+// fails to connect, lobby updates are simply not published.
+func NewNotifier(discordBotToken string, discordChannelId string) Notifier {
+	if discordBotToken == "" || discordChannelId == "" {
+		log.Println("Discord not configured, lobby updates will not be published")
+		return &nopNotifier{}
+	}
+
+	bot, err := NewDiscordBot(discordBotToken, discordChannelId)
+	if err != nil {
+		log.Printf("Failed to start Discord bot, lobby updates will not be published: %v\n", err)
+		return &nopNotifier{}
+	}
+	return bot
+}
+
+type nopNotifier struct{}
+
+func (n *nopNotifier) SendMessage(string) {}
+
+func (n *nopNotifier) Close() {}
+
+// isSilent reports whether the notifier drops every message, the lobby then skips rendering them
+func isSilent(notifier Notifier) bool {
+	_, ok := notifier.(*nopNotifier)
+	return ok
+}
@@ -7,44 +7,133 @@ import (
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
 )
 
+// maxMessageSize caps a single message from any client, websocket, TCP or polling
+const maxMessageSize = 64 * 1024
+
+// OctapodHandler owns the connected octapods, mu guards the maps and is taken before any octapod lock,
+// see Lobby for the whole lock order. Octapods never block on the handler: writes are queued to their writer
+// goroutine, and the read loop only calls back into the handler, for the round, its moves and its disconnect,
+// without holding its own lock. A disconnect during PingAll waits for it, then finds the octapod gone.
 type OctapodHandler struct {
 	mu       sync.Mutex
 	octapods map[string]*model.Octapod
+	banned   map[string]bool
+	// sessions maps the session of polling clients to their transport
+	sessions map[string]*pollTransport
+	// reaping is set while a goroutine checks the sessions, see reapSessions
+	reaping bool
+	// round is sent to octapods in the welcome
+	round       model.RoundInfo
+	connections ConnectionConfig
+	// moved is signalled when every octapod moved this tick, for the fast mode
+	moved chan struct{}
+}
+
+// OctapodInfo is the admin view of a connected octapod
+type OctapodInfo struct {
+	Id       string     `json:"id"`
+	Team     string     `json:"team,omitempty"`
+	Position pkg.Vector `json:"position"`
+	// Errors counts the messages dropped since the octapod joined, by code
+	Errors map[model.ErrorCode]int `json:"errors,omitempty"`
+	// Queued is the number of messages waiting to be written, a growing queue is a slow client
+	Queued int `json:"queued"`
+	// IdleTicks is the number of ticks since the octapod last moved, see ConnectionConfig.AfkTicks
+	IdleTicks int `json:"idleTicks"`
+	// Latency is how long the octapod took to answer its pings this round
+	Latency model.Latency `json:"latency"`
 }
 
 func NewOctapodHandler() *OctapodHandler {
 	return &OctapodHandler{
 		octapods: make(map[string]*model.Octapod),
+		banned:   make(map[string]bool),
+		sessions: make(map[string]*pollTransport),
+		moved:    make(chan struct{}, 1),
 	}
 }
 
-func (oh *OctapodHandler) UpdateAll(maze *Maze) []*model.Octapod {
+func (oh *OctapodHandler) UpdateAll(maze *Maze, rules model.ActionRules, collisions CollisionMode, objectives Objectives) []*model.Octapod {
 	oh.mu.Lock()
 	defer oh.mu.Unlock()
+
+	// Collisions are resolved where the octapods end up, after teleporters, traps and ice
+	moves := make([]*plannedMove, 0, len(oh.octapods))
+	for id, octapod := range oh.octapods {
+		world := maze.worldFor(octapod.GetInventory())
+		from := octapod.GetPosition()
+		moves = append(moves, &plannedMove{
+			id:   id,
+			from: from,
+			to:   maze.Enter(world, from, octapod.PlanUpdate(world, rules)),
+		})
+	}
+
+	// Octapods arriving on a coin at once are served in id order, like the collisions
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].id < moves[j].id
+	})
+	resolveCollisions(moves, collisions)
+
 	solvedOctapods := make([]*model.Octapod, 0)
-	for _, octapod := range oh.octapods {
-		newPosition := octapod.TryUpdate()
-		if maze.IsSolved(newPosition) {
+	for _, move := range moves {
+		octapod := oh.octapods[move.id]
+		if move.reverted {
+			octapod.Block("another octapod is in the way")
+		}
+		newPosition := octapod.ApplyUpdate(move.to)
+		if newPosition != move.from {
+			octapod.Collect(maze.Collect(newPosition))
+			if cost := maze.MudCost(newPosition); cost > 0 {
+				octapod.Stick(cost)
+			}
+		}
+		if objectives.IsSolved(maze, newPosition, octapod.GetInventory()) {
 			solvedOctapods = append(solvedOctapods, octapod)
 		}
 	}
 	return solvedOctapods
 }
 
-func (oh *OctapodHandler) PingAll(tickId string, status model.Status, maze *Maze) {
+func (oh *OctapodHandler) PingAll(tickId string, status model.Status, maze *Maze, sensors SensorConfig, teams TeamConfig, objectives Objectives) {
 	oh.mu.Lock()
 	defer oh.mu.Unlock()
+
+	relayTeamMessages(oh.octapods, teams)
+
+	positions := make(map[string]pkg.Vector, len(oh.octapods))
+	for id, octapod := range oh.octapods {
+		positions[id] = octapod.GetPosition()
+	}
+
 	for _, octapod := range oh.octapods {
-		position := octapod.GetPosition()
-		sensor := maze.GetSensor(position)
-		if !maze.IsSolved(position) {
-			status = model.Solved
+		position := positions[octapod.GetId()]
+
+		var others []pkg.Vector
+		if sensors.Proximity {
+			others = make([]pkg.Vector, 0, len(positions)-1)
+			for id, other := range positions {
+				if id != octapod.GetId() {
+					others = append(others, other)
+				}
+			}
 		}
 
-		err := octapod.Ping(tickId, sensor, status)
+		sensor := maze.ReadSensor(position, sensors, others)
+		if octapod.ConsumeScan() {
+			sensor.Scan = maze.Scan(position)
+		}
+		octapodStatus := status
+		if objectives.IsSolved(maze, position, octapod.GetInventory()) {
+			octapodStatus = model.Solved
+		}
+
+		seen := append(maze.Surroundings(position), sensor.Scan...)
+		err := octapod.Ping(tickId, sensor, seen, octapodStatus)
 		if err != nil {
 			log.Println("Error pinging", octapod.GetId(), err)
 			octapod.Disconnect()
@@ -54,64 +143,325 @@ func (oh *OctapodHandler) PingAll(tickId string, status model.Status, maze *Maze
 	}
 }
 
-func (oh *OctapodHandler) HandleJoin(c *gin.Context) {
+// StartRound keeps the round for the welcome of new octapods and announces it to the others
+func (oh *OctapodHandler) StartRound(info model.RoundInfo) {
 	oh.mu.Lock()
 	defer oh.mu.Unlock()
+	oh.round = info
+	for _, octapod := range oh.octapods {
+		err := octapod.StartRound(info)
+		if err != nil {
+			log.Println("Error starting round for", octapod.GetId(), err)
+			octapod.Disconnect()
+			delete(oh.octapods, octapod.GetId())
+		}
+	}
+}
 
-	id := c.Query("id")
-	if id == "" {
-		c.String(400, "Missing id")
-		return
+// EndRound sends every octapod its result, solved maps the ids that solved the round
+// to the ticks it took them
+func (oh *OctapodHandler) EndRound(summary RoundSummary, solved map[string]int) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for id, octapod := range oh.octapods {
+		_, isSolved := solved[id]
+		err := octapod.EndRound(model.RoundEndMessage{
+			Round:    summary.Number,
+			Outcome:  string(summary.Outcome),
+			Solved:   isSolved,
+			Score:    summary.Scores[id],
+			Coverage: summary.Coverage[id],
+		})
+		if err != nil {
+			log.Println("Error ending round for", id, err)
+			octapod.Disconnect()
+			delete(oh.octapods, id)
+		}
 	}
+}
 
-	isValid, msg := pkg.IsValidID(id)
-	if !isValid {
-		c.String(400, msg)
-		return
+// SetRound updates the round for the welcome of new octapods, when its rules change during the round
+func (oh *OctapodHandler) SetRound(info model.RoundInfo) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.round = info
+}
+
+func (oh *OctapodHandler) GetRound() model.RoundInfo {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	return oh.round
+}
+
+// NotifyAll sends the status to every octapod without starting a new tick
+func (oh *OctapodHandler) NotifyAll(status model.Status) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for _, octapod := range oh.octapods {
+		err := octapod.SendStatus(status)
+		if err != nil {
+			log.Println("Error notifying", octapod.GetId(), err)
+			octapod.Disconnect()
+			delete(oh.octapods, octapod.GetId())
+		}
 	}
+}
 
-	log.Println("New connection attempt from", id)
+func (oh *OctapodHandler) HandleJoin(c *gin.Context) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
 
-	// Check if octapod already exists
-	if _, ok := oh.octapods[id]; ok {
-		c.String(400, "Octapod already exists")
+	id := c.Query("id")
+	team := c.Query("team")
+	if status, msg := oh.checkJoin(id, team); status != 0 {
+		c.String(status, msg)
 		return
 	}
 
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: model.Subprotocols,
 	}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	conn.SetReadLimit(maxMessageSize)
+
+	log.Println("New connection established, subprotocol", conn.Subprotocol())
+
+	codec := model.CodecFor(conn.Subprotocol())
+	oh.join(id, team, model.NewWebsocketTransport(conn, codec.Binary()), codec)
+}
 
-	log.Println("New connection established")
+// checkJoin returns the HTTP status and the reason to refuse the join, the status is 0 when allowed.
+// The caller must hold the lock.
+func (oh *OctapodHandler) checkJoin(id string, team string) (int, string) {
+	if id == "" {
+		return 400, "Missing id"
+	}
 
+	isValid, msg := pkg.IsValidID(id)
+	if !isValid {
+		return 400, msg
+	}
+
+	log.Println("New connection attempt from", id)
+
+	if team != "" {
+		isValid, msg := pkg.IsValidID(team)
+		if !isValid {
+			return 400, "Team: " + msg
+		}
+	}
+
+	if oh.banned[id] {
+		return 403, "Octapod is banned"
+	}
+
+	// Check if octapod already exists
+	if _, ok := oh.octapods[id]; ok {
+		return 400, "Octapod already exists"
+	}
+	return 0, ""
+}
+
+// join adds the octapod on the transport to the game, whatever carries it.
+// The caller must hold the lock and have checked the join.
+func (oh *OctapodHandler) join(id string, team string, transport model.Transport, codec model.Codec) *model.Octapod {
+	var octapod *model.Octapod
 	onDisconnect := func(octapodId string) {
 		oh.mu.Lock()
 		defer oh.mu.Unlock()
+		// A kicked octapod may have joined again under the same id before its old connection ended
+		if oh.octapods[octapodId] != octapod {
+			return
+		}
 		log.Printf("Octapod %s disconnected, removing from map\n", octapodId)
 		delete(oh.octapods, octapodId)
 	}
-	octapod := model.NewOctapod(id, pkg.ZeroVec2(), conn, onDisconnect)
+	octapod = model.NewOctapod(id, team, pkg.ZeroVec2(), transport, codec, oh.GetRound, oh.onMove, onDisconnect)
+	octapod.SetIdleTimeout(oh.connections.IdleTimeout)
+	octapod.SetResponseWindow(oh.connections.ResponseWindow)
+	octapod.SetRound(oh.round)
 
 	oh.octapods[id] = octapod
 	octapod.Run()
+	return octapod
 }
 
-func (oh *OctapodHandler) GetOctapodPositionSet() map[pkg.Vector]string {
+// GetOctapodPositionSet maps every occupied cell to the ids of the octapods in it
+func (oh *OctapodHandler) GetOctapodPositionSet() map[pkg.Vector][]string {
 	// TODO make sure this lock doesn't mess everything up
 	oh.mu.Lock()
 	defer oh.mu.Unlock()
-	positions := make(map[pkg.Vector]string)
+	positions := make(map[pkg.Vector][]string)
 	for _, octapod := range oh.octapods {
-		positions[octapod.GetPosition()] = octapod.GetId()
+		position := octapod.GetPosition()
+		positions[position] = append(positions[position], octapod.GetId())
+	}
+	for _, ids := range positions {
+		sort.Strings(ids)
 	}
 	return positions
 }
 
+// ResetAll sends every octapod back to the start for a new round
+func (oh *OctapodHandler) ResetAll(start pkg.Vector) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for _, octapod := range oh.octapods {
+		octapod.Reset(start)
+	}
+}
+
+// GetCoverage returns the share of the open cells each octapod explored
+func (oh *OctapodHandler) GetCoverage(openCells int) map[string]float64 {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	coverage := make(map[string]float64, len(oh.octapods))
+	for id, octapod := range oh.octapods {
+		coverage[id] = float64(octapod.GetExploredCount()) / float64(max(openCells, 1))
+	}
+	return coverage
+}
+
+// GetScores scores every octapod still connected, solved maps the ids that solved the round
+// to the ticks it took them
+func (oh *OctapodHandler) GetScores(objectives Objectives, solved map[string]int, optimalCost int) map[string]int {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	scores := make(map[string]int, len(oh.octapods))
+	for id, octapod := range oh.octapods {
+		scores[id] = objectives.Score(octapod.GetInventory(), solved[id], optimalCost)
+	}
+	return scores
+}
+
+// GetLatencies returns how long each octapod took to answer its pings this round
+func (oh *OctapodHandler) GetLatencies() map[string]model.Latency {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	latencies := make(map[string]model.Latency, len(oh.octapods))
+	for id, octapod := range oh.octapods {
+		latencies[id] = octapod.GetLatency()
+	}
+	return latencies
+}
+
+// RespawnAll sends every octapod back to the start, keeping what they explored
+func (oh *OctapodHandler) RespawnAll(start pkg.Vector) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for _, octapod := range oh.octapods {
+		octapod.Respawn(start)
+	}
+}
+
+func (oh *OctapodHandler) onMove(id string) {
+	if oh.AllMoved() {
+		select {
+		case oh.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Moved is signalled when the last octapod moves, the signal can be stale by the time it is read
+func (oh *OctapodHandler) Moved() <-chan struct{} {
+	return oh.moved
+}
+
+// AllMoved reports whether every octapod pinged this tick has moved
+func (oh *OctapodHandler) AllMoved() bool {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	for _, octapod := range oh.octapods {
+		if octapod.IsAwaitingMove() {
+			return false
+		}
+	}
+	return len(oh.octapods) > 0
+}
+
 func (oh *OctapodHandler) GetOctapodCount() int {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
 	return len(oh.octapods)
 }
+
+func (oh *OctapodHandler) ListOctapods() []OctapodInfo {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	infos := make([]OctapodInfo, 0, len(oh.octapods))
+	for _, octapod := range oh.octapods {
+		infos = append(infos, OctapodInfo{
+			Id:        octapod.GetId(),
+			Team:      octapod.GetTeam(),
+			Position:  octapod.GetPosition(),
+			Errors:    octapod.GetErrorCounts(),
+			Queued:    octapod.GetQueued(),
+			IdleTicks: octapod.GetIdleTicks(),
+			Latency:   octapod.GetLatency(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+// Kick disconnects the octapod, it is free to join again
+func (oh *OctapodHandler) Kick(id string) bool {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	return oh.kick(id, false)
+}
+
+func (oh *OctapodHandler) kick(id string, banned bool) bool {
+	octapod, ok := oh.octapods[id]
+	if !ok {
+		return false
+	}
+	log.Printf("Kicking octapod %s\n", id)
+	delete(oh.octapods, id)
+	reason := "kicked by an admin"
+	if banned {
+		reason = "banned by an admin"
+	}
+	if err := octapod.SendKick(reason, banned); err != nil {
+		log.Println("Error sending kick to", id, err)
+	}
+	octapod.Disconnect()
+	return true
+}
+
+// Ban kicks the octapod and refuses any further join with that id
+func (oh *OctapodHandler) Ban(id string) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.banned[id] = true
+	oh.kick(id, true)
+}
+
+func (oh *OctapodHandler) Unban(id string) bool {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	if !oh.banned[id] {
+		return false
+	}
+	delete(oh.banned, id)
+	return true
+}
+
+func (oh *OctapodHandler) GetBanned() []string {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	banned := make([]string, 0, len(oh.banned))
+	for id := range oh.banned {
+		banned = append(banned, id)
+	}
+	sort.Strings(banned)
+	return banned
+}
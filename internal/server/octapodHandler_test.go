@@ -0,0 +1,678 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gbccsclub/octopod-challenge/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	botsPerTransport = 4
+	stressDuration   = 3 * time.Second
+	// stressTimeout fails the test when the lobby deadlocks instead of hanging
+	stressTimeout = 30 * time.Second
+)
+
+// bot is a simulated client on one of the transports
+type bot interface {
+	receive() ([][]byte, error)
+	send(data []byte) error
+	close()
+}
+
+// TestConcurrentClients plays rounds with websocket, msgpack, TCP and polling bots and a spectator
+// while the admin pauses, steps, resumes, kicks and reconfigures the lobby. Run it with -race.
+func TestConcurrentClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := NewConfig()
+	config.TickInterval = 50
+	config.MazeSize = 8
+	lobby := NewLobby(config, NewConfigStore(t.TempDir()+"/config.yaml"))
+	lobby.notifier = &nopNotifier{}
+	lobby.Start()
+	defer lobby.Stop()
+
+	server, tcpAddress := startTestServer(t, lobby)
+	defer server.Close()
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dialers := map[string]func(id string) (bot, error){
+		"ws": func(id string) (bot, error) { return dialWebsocket(ws, id, "") },
+		"mp": func(id string) (bot, error) { return dialWebsocket(ws, id, model.MsgpackSubprotocol) },
+		"tcp": func(id string) (bot, error) {
+			return dialTcp(tcpAddress, id)
+		},
+		"poll": func(id string) (bot, error) { return dialPoll(server.URL, id) },
+	}
+	ids := make([]string, 0, len(dialers)*botsPerTransport)
+
+	stopBots := make(chan struct{})
+	stopAdmin := make(chan struct{})
+	finished := make(chan struct{})
+	var states, moves, maxCount atomic.Int64
+	var bots, admin sync.WaitGroup
+
+	for transport, dial := range dialers {
+		for i := 0; i < botsPerTransport; i++ {
+			id := botId(transport, i)
+			ids = append(ids, id)
+			bots.Add(1)
+			go func() {
+				defer bots.Done()
+				playBot(id, dial, stopBots, &moves)
+			}()
+		}
+	}
+	sort.Strings(ids)
+
+	bots.Add(1)
+	go func() {
+		defer bots.Done()
+		spectate(t, ws, stopBots, &states)
+	}()
+	admin.Add(2)
+	go func() {
+		defer admin.Done()
+		driveAdmin(lobby, stopAdmin)
+	}()
+	// Joins, leaves and kicks race each other, the count must never go over the number of bots
+	go func() {
+		defer admin.Done()
+		for {
+			select {
+			case <-stopAdmin:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			count := int64(lobby.OctapodHandler.GetOctapodCount())
+			if count > maxCount.Load() {
+				maxCount.Store(count)
+			}
+		}
+	}()
+
+	var joined []string
+	go func() {
+		defer close(finished)
+		time.Sleep(stressDuration)
+		close(stopAdmin)
+		admin.Wait()
+
+		// Every bot joins again after its last kick, the disconnect of a kicked connection
+		// must never remove the octapod that took its id
+		joined = waitForOctapods(lobby, len(ids), 5*time.Second)
+		for end := time.Now().Add(300 * time.Millisecond); time.Now().Before(end) && slices.Equal(joined, ids); {
+			time.Sleep(10 * time.Millisecond)
+			joined = octapodIds(lobby)
+		}
+
+		close(stopBots)
+		bots.Wait()
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(stressTimeout):
+		t.Fatal("lobby deadlocked")
+	}
+
+	if states.Load() == 0 {
+		t.Error("spectator got no state")
+	}
+	if moves.Load() == 0 {
+		t.Error("no bot moved")
+	}
+	if count := maxCount.Load(); count > int64(len(ids)) {
+		t.Errorf("%d octapods registered for %d bots", count, len(ids))
+	}
+	if !slices.Equal(joined, ids) {
+		t.Errorf("expected every bot to be back after the kicks, got %v", joined)
+	}
+
+	// Every bot left, their disconnects must all be processed
+	if left := waitForOctapods(lobby, 0, 5*time.Second); len(left) != 0 {
+		t.Errorf("octapods left after every bot disconnected: %v", left)
+	}
+	if count := lobby.Spectators.GetSpectatorCount(); count != 0 {
+		t.Errorf("%d spectators left after disconnecting", count)
+	}
+}
+
+// TestRejoinAfterKick checks the disconnect of a kicked connection doesn't remove the octapod
+// that joined again under the same id, for many ids at once
+func TestRejoinAfterKick(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := NewConfig()
+	config.TickInterval = 50
+	lobby := NewLobby(config, NewConfigStore(t.TempDir()+"/config.yaml"))
+	lobby.notifier = &nopNotifier{}
+	lobby.Start()
+	defer lobby.Stop()
+
+	server, _ := startTestServer(t, lobby)
+	defer server.Close()
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+	oh := lobby.OctapodHandler
+
+	const rejoins = 8
+	ids := make([]string, rejoins)
+	seconds := make([]*tcpBot, rejoins)
+	var wg sync.WaitGroup
+	for i := range ids {
+		ids[i] = botId("ws", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first, err := dialWebsocket(ws, ids[i], "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer first.close()
+
+			// The kicked connection can only report its disconnect once the lock is released,
+			// after the new octapod took its id
+			client, conn := net.Pipe()
+			oh.mu.Lock()
+			kicked := oh.kick(ids[i], false)
+			if kicked {
+				oh.join(ids[i], "", newTcpTransport(conn), model.CodecFor(model.JsonSubprotocol))
+			}
+			oh.mu.Unlock()
+			if !kicked {
+				t.Error("octapod did not join:", ids[i])
+				return
+			}
+			seconds[i] = &tcpBot{conn: client, reader: bufio.NewReader(client)}
+
+			for {
+				if _, err := first.receive(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, second := range seconds {
+			if second != nil {
+				second.close()
+			}
+		}
+	}()
+	if t.Failed() {
+		return
+	}
+
+	// The server closed every kicked connection, give their read loops a few ticks to report it
+	for end := time.Now().Add(300 * time.Millisecond); time.Now().Before(end); {
+		if joined := octapodIds(lobby); !slices.Equal(joined, ids) {
+			t.Fatalf("expected the %d rejoined octapods, got %v", rejoins, joined)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The rejoined octapods are still pinged
+	for i, second := range seconds {
+		if !receivesPing(second) {
+			t.Errorf("%s is no longer pinged after rejoining", ids[i])
+		}
+	}
+}
+
+// TestJoinLeaveCount checks the registered octapods match the open connections after they joined and left at once
+func TestJoinLeaveCount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// TCP joins wait for their first ping
+	config := NewConfig()
+	config.TickInterval = 50
+	lobby := NewLobby(config, NewConfigStore(t.TempDir()+"/config.yaml"))
+	lobby.notifier = &nopNotifier{}
+	lobby.Start()
+	defer lobby.Stop()
+
+	server, tcpAddress := startTestServer(t, lobby)
+	defer server.Close()
+	ws := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	const clients, rounds = 16, 10
+	var wg sync.WaitGroup
+	var expected []string
+	open := make([]bot, clients)
+	for i := 0; i < clients; i++ {
+		id := botId("churn", i)
+		// Every other client stays connected after its last join
+		if i%2 == 0 {
+			expected = append(expected, id)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				var b bot
+				var err error
+				// The previous leave may not be processed yet, the id is still taken
+				for attempt := 0; attempt < 100; attempt++ {
+					if i%3 == 0 {
+						b, err = dialTcp(tcpAddress, id)
+					} else {
+						b, err = dialWebsocket(ws, id, "")
+					}
+					if err == nil {
+						break
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				if err != nil {
+					t.Error(id, "could not join again:", err)
+					return
+				}
+				if round == rounds-1 && i%2 == 0 {
+					open[i] = b
+					return
+				}
+				b.close()
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, b := range open {
+			if b != nil {
+				b.close()
+			}
+		}
+	}()
+	sort.Strings(expected)
+
+	if joined := waitForOctapods(lobby, len(expected), 5*time.Second); !slices.Equal(joined, expected) {
+		t.Errorf("expected %v, got %v", expected, joined)
+	}
+}
+
+func startTestServer(t *testing.T, lobby *Lobby) (*httptest.Server, string) {
+	router := gin.New()
+	router.GET("/join", lobby.OctapodHandler.HandleJoin)
+	router.GET("/spectate", lobby.Spectators.HandleSpectate)
+	router.POST("/poll/join", lobby.OctapodHandler.HandlePollJoin)
+	router.GET("/poll/:session", lobby.OctapodHandler.HandlePoll)
+	router.POST("/poll/:session", lobby.OctapodHandler.HandlePollSubmit)
+	router.DELETE("/poll/:session", lobby.OctapodHandler.HandlePollLeave)
+	server := httptest.NewServer(router)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = lobby.OctapodHandler.ServeTCP(listener)
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return server, listener.Addr().String()
+}
+
+func botId(transport string, i int) string {
+	return fmt.Sprintf("bot_%s_%d", transport, i)
+}
+
+// octapodIds returns the sorted ids of the registered octapods
+func octapodIds(lobby *Lobby) []string {
+	octapods := lobby.OctapodHandler.ListOctapods()
+	ids := make([]string, 0, len(octapods))
+	for _, octapod := range octapods {
+		ids = append(ids, octapod.Id)
+	}
+	return ids
+}
+
+// waitForOctapods returns the registered ids once there are count of them, or when the timeout runs out
+func waitForOctapods(lobby *Lobby, count int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	ids := octapodIds(lobby)
+	for len(ids) != count && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		ids = octapodIds(lobby)
+	}
+	return ids
+}
+
+// receivesPing reads until a ping arrives, or fails after a second
+func receivesPing(b *tcpBot) bool {
+	_ = b.conn.SetReadDeadline(time.Now().Add(time.Second))
+	defer b.conn.SetReadDeadline(time.Time{})
+	for {
+		messages, err := b.receive()
+		if err != nil {
+			return false
+		}
+		for _, message := range messages {
+			if pingTickId(message) != "" {
+				return true
+			}
+		}
+	}
+}
+
+// playBot answers every ping with a random move, joining again when kicked until stopped
+func playBot(id string, dial func(id string) (bot, error), stop chan struct{}, moves *atomic.Int64) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		b, err := dial(id)
+		if err != nil {
+			// Still connected under the id, the kick is not processed yet
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+				b.close()
+			case <-done:
+			}
+		}()
+		play(b, moves)
+		close(done)
+		b.close()
+	}
+}
+
+func play(b bot, moves *atomic.Int64) {
+	directions := []model.MoveDirection{model.Up, model.Down, model.Left, model.Right}
+	for {
+		messages, err := b.receive()
+		if err != nil {
+			return
+		}
+		for _, message := range messages {
+			tickId := pingTickId(message)
+			if tickId == "" {
+				continue
+			}
+			move, _ := json.Marshal(model.MoveMessage{TickId: tickId, MoveDirection: directions[rand.Intn(len(directions))]})
+			if err := b.send(move); err != nil {
+				return
+			}
+			moves.Add(1)
+		}
+	}
+}
+
+// pingTickId returns the tick of a bare ping, bots never say hello
+func pingTickId(message []byte) string {
+	var ping struct {
+		TickId string `json:"tickId"`
+	}
+	if json.Unmarshal(message, &ping) != nil {
+		return ""
+	}
+	return ping.TickId
+}
+
+// driveAdmin goes through the admin operations in a loop, as fast as a busy admin panel would
+func driveAdmin(lobby *Lobby, stop chan struct{}) {
+	tickIntervals := []int{50, 80}
+	mazeSizes := []int{8, 10}
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(15 * time.Millisecond):
+		}
+
+		switch i % 8 {
+		case 0:
+			lobby.Pause()
+		case 1:
+			_ = lobby.Step()
+		case 2:
+			lobby.Resume()
+		case 3:
+			if octapods := lobby.OctapodHandler.ListOctapods(); len(octapods) > 0 {
+				lobby.OctapodHandler.Kick(octapods[rand.Intn(len(octapods))].Id)
+			}
+		case 4:
+			window := rand.Intn(100)
+			_, _ = lobby.ApplyConfig(ConfigUpdate{TickInterval: &tickIntervals[i%2], ResponseWindow: &window})
+		case 5:
+			if i%5 == 0 {
+				_, _ = lobby.ApplyConfig(ConfigUpdate{MazeSize: &mazeSizes[i%2]})
+			}
+		case 6:
+			fast := i%3 == 0
+			_, _ = lobby.ApplyConfig(ConfigUpdate{FastMode: &fast})
+		case 7:
+			lobby.RequestRestart()
+		}
+		lobby.GetStatus()
+		lobby.GetRounds()
+		lobby.OctapodHandler.ListOctapods()
+	}
+}
+
+func spectate(t *testing.T, ws string, stop chan struct{}, states *atomic.Int64) {
+	conn, _, err := websocket.DefaultDialer.Dial(ws+"/spectate", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var envelope model.Envelope
+		if json.Unmarshal(data, &envelope) == nil && envelope.Type == model.MsgState {
+			states.Add(1)
+		}
+	}
+}
+
+type websocketBot struct {
+	conn  *websocket.Conn
+	codec model.Codec
+}
+
+func dialWebsocket(ws string, id string, subprotocol string) (*websocketBot, error) {
+	dialer := *websocket.DefaultDialer
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	conn, _, err := dialer.Dial(ws+"/join?id="+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &websocketBot{conn: conn, codec: model.CodecFor(conn.Subprotocol())}, nil
+}
+
+// receive returns the message as JSON whatever the codec
+func (b *websocketBot) receive() ([][]byte, error) {
+	_, data, err := b.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if !b.codec.Binary() {
+		return [][]byte{data}, nil
+	}
+
+	var ping model.PingMessage
+	if err := b.codec.Decode(data, &ping); err != nil {
+		return nil, nil
+	}
+	message, err := json.Marshal(ping)
+	return [][]byte{message}, err
+}
+
+func (b *websocketBot) send(data []byte) error {
+	if !b.codec.Binary() {
+		return b.conn.WriteMessage(websocket.TextMessage, data)
+	}
+
+	var move model.MoveMessage
+	if err := json.Unmarshal(data, &move); err != nil {
+		return err
+	}
+	encoded, err := b.codec.Encode(move)
+	if err != nil {
+		return err
+	}
+	return b.conn.WriteMessage(websocket.BinaryMessage, encoded)
+}
+
+func (b *websocketBot) close() {
+	_ = b.conn.Close()
+}
+
+type tcpBot struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTcp(address string, id string) (*tcpBot, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	join, _ := json.Marshal(tcpJoin{Id: id})
+	if _, err := conn.Write(append(join, '\n')); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	b := &tcpBot{conn: conn, reader: bufio.NewReader(conn)}
+	// A refused join is answered with an error line before the connection is closed
+	first, err := b.receive()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var envelope model.Envelope
+	if json.Unmarshal(first[0], &envelope) == nil && envelope.Type == model.MsgError {
+		conn.Close()
+		return nil, errors.New("join refused")
+	}
+	return b, nil
+}
+
+func (b *tcpBot) receive() ([][]byte, error) {
+	line, err := b.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{line}, nil
+}
+
+func (b *tcpBot) send(data []byte) error {
+	_, err := b.conn.Write(append(data, '\n'))
+	return err
+}
+
+func (b *tcpBot) close() {
+	_ = b.conn.Close()
+}
+
+type pollBot struct {
+	client  *http.Client
+	url     string
+	closed  atomic.Bool
+	session string
+}
+
+func dialPoll(base string, id string) (*pollBot, error) {
+	client := &http.Client{Timeout: pollWait + 5*time.Second}
+	response, err := client.Post(base+"/poll/join?id="+id, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(response.Status)
+	}
+
+	var joined struct {
+		Session string `json:"session"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&joined); err != nil {
+		return nil, err
+	}
+	return &pollBot{client: client, url: base + "/poll/" + joined.Session, session: joined.Session}, nil
+}
+
+func (b *pollBot) receive() ([][]byte, error) {
+	if b.closed.Load() {
+		return nil, io.EOF
+	}
+	response, err := b.client.Get(b.url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(response.Status)
+	}
+
+	var messages []json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&messages); err != nil {
+		return nil, err
+	}
+	result := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message)
+	}
+	return result, nil
+}
+
+func (b *pollBot) send(data []byte) error {
+	response, err := b.client.Post(b.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusGone || response.StatusCode == http.StatusNotFound {
+		return errors.New(response.Status)
+	}
+	return nil
+}
+
+func (b *pollBot) close() {
+	if b.closed.Swap(true) {
+		return
+	}
+	request, _ := http.NewRequest(http.MethodDelete, b.url, nil)
+	if response, err := b.client.Do(request); err == nil {
+		response.Body.Close()
+	}
+}
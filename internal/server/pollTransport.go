@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gbccsclub/octopod-challenge/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// pollWait is how long a poll waits for a message before returning empty
+	pollWait = 25 * time.Second
+	// pollMaxQueue caps the messages kept for a client between two polls
+	pollMaxQueue = 64
+	// pollMaxInbox caps the messages submitted but not yet read by the octapod
+	pollMaxInbox = 8
+	// pollReapInterval is how often the sessions are checked for clients that stopped polling
+	pollReapInterval = 2 * time.Second
+)
+
+var (
+	errPollIdle      = errors.New("client stopped polling")
+	errPollQueueFull = errors.New("too many messages waiting for the client")
+	errPollInboxFull = errors.New("too many messages submitted this tick")
+)
+
+// pollTransport keeps the messages for a client polling over HTTP, and hands its submitted messages
+// to the octapod. Messages stay in the outbox until a poll response carrying them was written,
+// a poll that fails returns them again on the next one.
+type pollTransport struct {
+	mu     sync.Mutex
+	outbox [][]byte
+	// sent is the number of messages taken off the outbox since the session started
+	sent     int
+	lastPoll time.Time
+	// polling is the number of polls waiting, the client is never idle meanwhile
+	polling     int
+	idleTimeout time.Duration
+
+	// ready is signalled when the outbox gets a message
+	ready     chan struct{}
+	inbox     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPollTransport() *pollTransport {
+	return &pollTransport{
+		lastPoll: time.Now(),
+		ready:    make(chan struct{}, 1),
+		inbox:    make(chan []byte, pollMaxInbox),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (t *pollTransport) Read() ([]byte, error) {
+	select {
+	case data := <-t.inbox:
+		return data, nil
+	case <-t.closed:
+		return nil, io.EOF
+	}
+}
+
+// Write queues the message for the next poll, it fails once the client stopped polling
+func (t *pollTransport) Write(data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.isIdle() {
+		return errPollIdle
+	}
+	if len(t.outbox) >= pollMaxQueue {
+		return errPollQueueFull
+	}
+	t.outbox = append(t.outbox, data)
+
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SetWriteDeadline does nothing, writes only queue the message for the next poll
+func (t *pollTransport) SetWriteDeadline(deadline time.Time) error {
+	return nil
+}
+
+func (t *pollTransport) SetIdleTimeout(timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idleTimeout = timeout
+}
+
+// Heartbeat does nothing, polling is the sign of life
+func (t *pollTransport) Heartbeat() error {
+	return nil
+}
+
+func (t *pollTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+	})
+	return nil
+}
+
+// isIdle reports whether the client stopped polling for longer than the idle timeout, the caller must hold the lock
+func (t *pollTransport) isIdle() bool {
+	return t.polling == 0 && t.idleTimeout > 0 && time.Since(t.lastPoll) > t.idleTimeout
+}
+
+func (t *pollTransport) isClosed() bool {
+	select {
+	case <-t.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *pollTransport) submit(data []byte) error {
+	select {
+	case <-t.closed:
+		return io.EOF
+	default:
+	}
+
+	select {
+	case t.inbox <- data:
+		return nil
+	default:
+		return errPollInboxFull
+	}
+}
+
+// poll returns the queued messages without taking them off the outbox, waiting for one up to pollWait.
+// The cursor is passed to ack once the messages reached the client.
+func (t *pollTransport) poll(ctx context.Context) ([][]byte, int, error) {
+	timer := time.NewTimer(pollWait)
+	defer timer.Stop()
+
+	t.mu.Lock()
+	t.polling++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.polling--
+		t.lastPoll = time.Now()
+		t.mu.Unlock()
+	}()
+
+	for {
+		t.mu.Lock()
+		messages := append([][]byte(nil), t.outbox...)
+		cursor := t.sent + len(messages)
+		t.mu.Unlock()
+
+		if len(messages) > 0 {
+			return messages, cursor, nil
+		}
+
+		select {
+		case <-t.ready:
+		case <-t.closed:
+			return nil, 0, io.EOF
+		case <-timer.C:
+			return nil, cursor, nil
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// ack takes the messages up to the cursor off the outbox. Two polls at once may both return
+// the same messages, the client gets them twice rather than not at all.
+func (t *pollTransport) ack(cursor int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if delivered := cursor - t.sent; delivered > 0 {
+		t.outbox = t.outbox[delivered:]
+		t.sent = cursor
+	}
+}
+
+// HandlePollJoin joins an octapod that polls for its messages over HTTP, for clients without websockets.
+// The returned session is used to poll, submit and leave.
+func (oh *OctapodHandler) HandlePollJoin(c *gin.Context) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+
+	id := c.Query("id")
+	team := c.Query("team")
+	if status, msg := oh.checkJoin(id, team); status != 0 {
+		c.String(status, msg)
+		return
+	}
+
+	session := uuid.New().String()
+	transport := newPollTransport()
+	oh.sessions[session] = transport
+	oh.join(id, team, transport, model.CodecFor(model.JsonSubprotocol))
+	if !oh.reaping {
+		oh.reaping = true
+		go oh.reapSessions()
+	}
+
+	log.Println("New poll session established for", id)
+	c.JSON(200, gin.H{"session": session})
+}
+
+// HandlePoll returns the messages sent to the octapod since the last poll, in order
+func (oh *OctapodHandler) HandlePoll(c *gin.Context) {
+	transport, ok := oh.getSession(c.Param("session"))
+	if !ok {
+		c.String(404, "Unknown session")
+		return
+	}
+
+	messages, cursor, err := transport.poll(c.Request.Context())
+	if errors.Is(err, io.EOF) {
+		c.String(410, "Session closed")
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	raw := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		raw = append(raw, message)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		c.String(500, "Messages cannot be encoded")
+		return
+	}
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(200)
+	if _, err = c.Writer.Write(data); err == nil {
+		err = flushResponse(c.Writer)
+	}
+	if err != nil {
+		log.Println("Poll response failed, keeping the messages for the next poll:", err)
+		return
+	}
+	transport.ack(cursor)
+}
+
+// flushResponse sends the response and reports whether the connection took it, gin's own Flush drops the error
+func flushResponse(w gin.ResponseWriter) error {
+	var writer http.ResponseWriter = w
+	if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+		writer = unwrapper.Unwrap()
+	}
+	return http.NewResponseController(writer).Flush()
+}
+
+// HandlePollSubmit hands a single message, a hello or a move, to the octapod
+func (oh *OctapodHandler) HandlePollSubmit(c *gin.Context) {
+	transport, ok := oh.getSession(c.Param("session"))
+	if !ok {
+		c.String(404, "Unknown session")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxMessageSize))
+	if err != nil {
+		c.String(413, "Message too large")
+		return
+	}
+
+	err = transport.submit(data)
+	if errors.Is(err, io.EOF) {
+		c.String(410, "Session closed")
+		return
+	}
+	if err != nil {
+		c.String(429, err.Error())
+		return
+	}
+	c.Status(202)
+}
+
+// HandlePollLeave disconnects the octapod of the session
+func (oh *OctapodHandler) HandlePollLeave(c *gin.Context) {
+	transport, ok := oh.getSession(c.Param("session"))
+	if !ok {
+		c.String(404, "Unknown session")
+		return
+	}
+	_ = transport.Close()
+	c.Status(204)
+}
+
+// reapSessions disconnects the clients that stopped polling and forgets the sessions that ended,
+// until there are none left. Writes notice idle clients too, but only when there is something to send.
+func (oh *OctapodHandler) reapSessions() {
+	ticker := time.NewTicker(pollReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		oh.mu.Lock()
+		for session, transport := range oh.sessions {
+			transport.mu.Lock()
+			idle := transport.isIdle()
+			transport.mu.Unlock()
+
+			if idle {
+				log.Println("Poll session stopped polling, disconnecting")
+				_ = transport.Close()
+			}
+			if transport.isClosed() {
+				delete(oh.sessions, session)
+			}
+		}
+		if len(oh.sessions) == 0 {
+			oh.reaping = false
+			oh.mu.Unlock()
+			return
+		}
+		oh.mu.Unlock()
+	}
+}
+
+func (oh *OctapodHandler) getSession(session string) (*pollTransport, bool) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	transport, ok := oh.sessions[session]
+	return transport, ok
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"gbccsclub/octopod-challenge/internal/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxRoundHistory = 50
+
+type RoundOutcome string
+
+const (
+	RoundCompleted RoundOutcome = "completed"
+	RoundRestarted RoundOutcome = "restarted"
+)
+
+// RoundSummary is the record of a finished round kept in the lobby history
+type RoundSummary struct {
+	Number       int          `json:"number"`
+	StartedAt    time.Time    `json:"startedAt"`
+	EndedAt      time.Time    `json:"endedAt"`
+	MazeSize     int          `json:"mazeSize"`
+	PeakOctapods int          `json:"peakOctapods"`
+	Solved       []string     `json:"solved"`
+	Outcome      RoundOutcome `json:"outcome"`
+	// OptimalCost is the weighted shortest path from the start to the exit, in ticks
+	OptimalCost int `json:"optimalCost"`
+
+	// Coverage maps each octapod still connected at the end to the share of the maze it explored
+	Coverage map[string]float64 `json:"coverage"`
+	// Scores maps each octapod still connected at the end to its score, see Objectives
+	Scores map[string]int `json:"scores"`
+	// Latency maps each octapod still connected at the end to how long it took to answer its pings
+	Latency map[string]model.Latency `json:"latency"`
+}
+
+// FormatCoverage lists the coverage as percentages, for the admin panel
+func (s RoundSummary) FormatCoverage() string {
+	ids := make([]string, 0, len(s.Coverage))
+	for id := range s.Coverage {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%s %.0f%%", id, s.Coverage[id]*100))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatScores lists the scores, highest first, for the admin panel
+func (s RoundSummary) FormatScores() string {
+	ids := make([]string, 0, len(s.Scores))
+	for id := range s.Scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if s.Scores[ids[i]] != s.Scores[ids[j]] {
+			return s.Scores[ids[i]] > s.Scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%s %d", id, s.Scores[id]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatLatency lists the average answer time of each octapod, for the admin panel
+func (s RoundSummary) FormatLatency() string {
+	ids := make([]string, 0, len(s.Latency))
+	for id := range s.Latency {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, fmt.Sprintf("%s %d ms", id, s.Latency[id].Average().Milliseconds()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// round tracks the state of the round in progress
+type round struct {
+	number       int
+	startedAt    time.Time
+	mazeSize     int
+	peakOctapods int
+	optimalCost  int
+	// solved maps the octapods that reached the exit in the Solving stage to the ticks it took
+	solved map[string]int
+}
+
+func newRound(number int, mazeSize int) *round {
+	return &round{
+		number:    number,
+		startedAt: time.Now(),
+		mazeSize:  mazeSize,
+		solved:    make(map[string]int),
+	}
+}
+
+func (r *round) summarize(outcome RoundOutcome, coverage map[string]float64, scores map[string]int, latency map[string]model.Latency) RoundSummary {
+	solved := make([]string, 0, len(r.solved))
+	for id := range r.solved {
+		solved = append(solved, id)
+	}
+	sort.Strings(solved)
+
+	return RoundSummary{
+		Number:       r.number,
+		StartedAt:    r.startedAt,
+		EndedAt:      time.Now(),
+		MazeSize:     r.mazeSize,
+		PeakOctapods: r.peakOctapods,
+		Solved:       solved,
+		Outcome:      outcome,
+		OptimalCost:  r.optimalCost,
+		Coverage:     coverage,
+		Scores:       scores,
+		Latency:      latency,
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/pkg"
+	"math"
+	"math/rand"
+)
+
+// scanRadius is how far a scan action reveals the maze
+const scanRadius = 2
+
+// signalNoise is the standard deviation of the noise added to the signal strength
+const signalNoise = 0.05
+
+// SensorConfig holds the optional sensors enabled for the round
+type SensorConfig struct {
+	RangeFinder     bool
+	Compass         bool
+	Signal          bool
+	Proximity       bool
+	ProximityRadius int
+}
+
+func (c *Config) sensorConfig() SensorConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return SensorConfig{
+		RangeFinder:     c.SensorRangeFinder,
+		Compass:         c.SensorCompass,
+		Signal:          c.SensorSignal,
+		Proximity:       c.SensorProximity,
+		ProximityRadius: c.ProximityRadius,
+	}
+}
+
+// ReadSensor returns the sensor at the point with the enabled optional sensors filled in.
+// Others holds the positions of every other octapod, only used for proximity.
+func (m *Maze) ReadSensor(point pkg.Vector, sensors SensorConfig, others []pkg.Vector) *pkg.Sensor {
+	sensor := m.GetSensor(point)
+
+	if sensors.RangeFinder {
+		sensor.Distances = m.readDistances(point)
+	}
+
+	if sensors.Compass {
+		bearing := m.bearingToExit(point)
+		sensor.Compass = &bearing
+	}
+
+	if sensors.Signal {
+		signal := m.signalStrength(point)
+		sensor.Signal = &signal
+	}
+
+	if sensors.Proximity {
+		sensor.Nearby = m.nearby(point, others, sensors.ProximityRadius)
+	}
+
+	sensor.Tiles = m.tilesAround(point)
+
+	return sensor
+}
+
+// Scan returns every cell within the scan radius of the point,
+// a square around it on square grids and a hexagon on hex grids
+func (m *Maze) Scan(point pkg.Vector) []pkg.Cell {
+	cells := make([]pkg.Cell, 0, (2*scanRadius+1)*(2*scanRadius+1))
+	for dy := -scanRadius; dy <= scanRadius; dy++ {
+		for dx := -scanRadius; dx <= scanRadius; dx++ {
+			if m.grid.Topology == pkg.Hex && pkg.Abs(dx)+pkg.Abs(dy)+pkg.Abs(dx+dy) > 2*scanRadius {
+				continue
+			}
+			cell := m.step(point, pkg.Vec2(dx, dy))
+			cells = append(cells, pkg.Cell{Vector: cell, Wall: !m.IsAvailable(cell), Tile: m.tiles[cell].Kind})
+		}
+	}
+	return cells
+}
+
+func (m *Maze) readDistances(point pkg.Vector) *pkg.Distances {
+	if m.grid.Topology != pkg.Hex {
+		return &pkg.Distances{
+			Left:  m.distanceToWall(point, pkg.Vec2Left()),
+			Right: m.distanceToWall(point, pkg.Vec2Right()),
+			Up:    m.distanceToWall(point, pkg.Vec2Up()),
+			Down:  m.distanceToWall(point, pkg.Vec2Down()),
+		}
+	}
+
+	upLeft := m.distanceToWall(point, pkg.Vec2(0, -1))
+	upRight := m.distanceToWall(point, pkg.Vec2(1, -1))
+	downLeft := m.distanceToWall(point, pkg.Vec2(-1, 1))
+	downRight := m.distanceToWall(point, pkg.Vec2(0, 1))
+	return &pkg.Distances{
+		Left:      m.distanceToWall(point, pkg.Vec2Left()),
+		Right:     m.distanceToWall(point, pkg.Vec2Right()),
+		UpLeft:    &upLeft,
+		UpRight:   &upRight,
+		DownLeft:  &downLeft,
+		DownRight: &downRight,
+	}
+}
+
+// distanceToWall counts the open cells in the direction, stopping after a full lap around a torus
+func (m *Maze) distanceToWall(point pkg.Vector, direction pkg.Vector) int {
+	distance := 0
+	limit := max(m.Width, m.Height)
+	for next := m.step(point, direction); m.IsAvailable(next) && distance < limit; next = m.step(next, direction) {
+		distance++
+	}
+	return distance
+}
+
+func (m *Maze) bearingToExit(point pkg.Vector) float64 {
+	dx, dy := m.grid.Offset(point, m.exitCell)
+
+	// Screen coordinates, y grows downwards
+	bearing := math.Atan2(dx, -dy) * 180 / math.Pi
+	if bearing < 0 {
+		bearing += 360
+	}
+	return bearing
+}
+
+func (m *Maze) signalStrength(point pkg.Vector) float64 {
+	distance := math.Hypot(m.grid.Offset(point, m.exitCell))
+	maxDistance := math.Hypot(float64(m.Width), float64(m.Height))
+
+	signal := 1 - distance/maxDistance + rand.NormFloat64()*signalNoise
+	return math.Max(0, math.Min(1, signal))
+}
+
+func (m *Maze) nearby(point pkg.Vector, others []pkg.Vector, radius int) []pkg.Vector {
+	offsets := make([]pkg.Vector, 0)
+	for _, other := range others {
+		if m.grid.Distance(point, other) <= radius {
+			offsets = append(offsets, m.grid.Delta(point, other))
+		}
+	}
+	return offsets
+}
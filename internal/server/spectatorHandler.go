@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/json"
+	"gbccsclub/octopod-challenge/internal/model"
+	"gbccsclub/octopod-challenge/pkg"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SpectatorHandler streams the lobby to read only websocket clients, for live visualisations.
+// Spectators are not octapods, they cannot move and are not counted in the lobby.
+type SpectatorHandler struct {
+	mu          sync.Mutex
+	spectators  map[*spectator]bool
+	idleTimeout time.Duration
+	// last is the latest state, sent to spectators as they join so they don't wait for a tick
+	last []byte
+}
+
+// SpectatorState is the whole lobby after a tick
+type SpectatorState struct {
+	Round    int                `json:"round"`
+	Tick     int                `json:"tick"`
+	Stage    model.Status       `json:"stage"`
+	Step     int                `json:"step"`
+	Paused   bool               `json:"paused"`
+	Maze     MazeSnapshot       `json:"maze"`
+	Octapods []SpectatedOctapod `json:"octapods"`
+	// Solved maps the octapods that solved the round to the ticks it took them
+	Solved map[string]int `json:"solved"`
+}
+
+type SpectatedOctapod struct {
+	Id       string     `json:"id"`
+	Team     string     `json:"team,omitempty"`
+	Position pkg.Vector `json:"position"`
+	Score    int        `json:"score"`
+}
+
+// MazeSnapshot is the maze at the current tick
+type MazeSnapshot struct {
+	Width    int          `json:"width"`
+	Height   int          `json:"height"`
+	Floors   int          `json:"floors"`
+	Topology pkg.Topology `json:"topology"`
+	// Walls has a string per row for every floor, '#' for a wall and '.' for a passage
+	Walls [][]string  `json:"walls"`
+	Doors []DoorState `json:"doors"`
+	Tiles []pkg.Tile  `json:"tiles"`
+	// Stairs lists the cells with stairs to the floor above
+	Stairs []pkg.Vector `json:"stairs"`
+	Exit   pkg.Vector   `json:"exit"`
+}
+
+type DoorState struct {
+	pkg.Vector
+	Open bool `json:"open"`
+}
+
+// Snapshot copies the layout of the maze at the current tick
+func (m *Maze) Snapshot() MazeSnapshot {
+	snapshot := MazeSnapshot{
+		Width:    m.Width,
+		Height:   m.Height,
+		Floors:   m.Floors,
+		Topology: m.grid.Topology,
+		Walls:    make([][]string, m.Floors),
+		Doors:    make([]DoorState, 0, len(m.doors)),
+		Tiles:    make([]pkg.Tile, 0, len(m.tiles)),
+		Stairs:   make([]pkg.Vector, 0, len(m.stairs)),
+		Exit:     m.exitCell,
+	}
+
+	for z := 0; z < m.Floors; z++ {
+		snapshot.Walls[z] = make([]string, m.Height)
+		row := make([]byte, m.Width)
+		for y := 0; y < m.Height; y++ {
+			for x := 0; x < m.Width; x++ {
+				row[x] = '.'
+				if m.isWall(pkg.Vec3(x, y, z)) {
+					row[x] = '#'
+				}
+			}
+			snapshot.Walls[z][y] = string(row)
+		}
+	}
+	for position, door := range m.doors {
+		snapshot.Doors = append(snapshot.Doors, DoorState{Vector: position, Open: door.IsOpen(m.tick)})
+	}
+	for _, tile := range m.tiles {
+		snapshot.Tiles = append(snapshot.Tiles, tile)
+	}
+	for position := range m.stairs {
+		snapshot.Stairs = append(snapshot.Stairs, position)
+	}
+	return snapshot
+}
+
+func NewSpectatorHandler() *SpectatorHandler {
+	return &SpectatorHandler{
+		spectators: make(map[*spectator]bool),
+	}
+}
+
+func (sh *SpectatorHandler) HandleSpectate(c *gin.Context) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	conn.SetReadLimit(maxMessageSize)
+
+	transport := model.NewWebsocketTransport(conn, false)
+	s := &spectator{
+		transport: transport,
+		writer:    model.NewWriter("spectator "+conn.RemoteAddr().String(), transport),
+	}
+
+	sh.mu.Lock()
+	sh.spectators[s] = true
+	s.writer.SetIdleTimeout(sh.idleTimeout)
+	if sh.last != nil {
+		_ = s.writer.Write(sh.last)
+	}
+	log.Println("New spectator connected, watching:", len(sh.spectators))
+	sh.mu.Unlock()
+
+	go s.writer.Run()
+	go sh.readLoop(s)
+}
+
+// SetIdleTimeout drops the spectators that stop answering the heartbeats, like octapods
+func (sh *SpectatorHandler) SetIdleTimeout(timeout time.Duration) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.idleTimeout = timeout
+	for s := range sh.spectators {
+		s.writer.SetIdleTimeout(timeout)
+	}
+}
+
+// IsWatched reports whether anybody is spectating, the lobby skips building the state otherwise
+func (sh *SpectatorHandler) IsWatched() bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return len(sh.spectators) > 0
+}
+
+// GetSpectatorCount returns the number of spectators, they are never counted as octapods
+func (sh *SpectatorHandler) GetSpectatorCount() int {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return len(sh.spectators)
+}
+
+// Broadcast queues the state for every spectator, the ones that fell behind are dropped
+func (sh *SpectatorHandler) Broadcast(state SpectatorState) {
+	data, err := json.Marshal(model.Envelope{Type: model.MsgState, V: model.ProtocolVersion, Data: state})
+	if err != nil {
+		log.Println("Error encoding spectator state:", err)
+		return
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.last = data
+	for s := range sh.spectators {
+		if err := s.writer.Write(data); err != nil {
+			s.writer.Close()
+			delete(sh.spectators, s)
+		}
+	}
+}
+
+func (sh *SpectatorHandler) remove(s *spectator) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.spectators, s)
+	// The state is only kept up to date while somebody watches
+	if len(sh.spectators) == 0 {
+		sh.last = nil
+	}
+}
+
+// spectator is a single read only connection, written through the same writer as octapods
+type spectator struct {
+	transport model.Transport
+	writer    *model.Writer
+}
+
+// readLoop answers every message with an error, spectators cannot move
+func (sh *SpectatorHandler) readLoop(s *spectator) {
+	refusal, _ := json.Marshal(model.Envelope{
+		Type: model.MsgError,
+		V:    model.ProtocolVersion,
+		Data: model.ErrorMessage{Code: model.Spectating, Message: "spectators cannot send messages"},
+	})
+	for {
+		if _, err := s.transport.Read(); err != nil {
+			s.writer.Close()
+			sh.remove(s)
+			return
+		}
+		_ = s.writer.Write(refusal)
+	}
+}
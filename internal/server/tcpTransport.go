@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"gbccsclub/octopod-challenge/internal/model"
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// tcpJoinTimeout is how long a TCP client has to send its join line
+const tcpJoinTimeout = 10 * time.Second
+
+// tcpJoin is the first line sent by a TCP client, the rest of the lines are the usual messages
+type tcpJoin struct {
+	Id   string `json:"id"`
+	Team string `json:"team"`
+}
+
+// tcpTransport carries newline delimited JSON over a plain TCP connection.
+// There are no heartbeats, quiet clients keep the connection alive by sending empty lines.
+// The lobby stops the idle clock while paused, so clients waiting for the next ping are not dropped.
+type tcpTransport struct {
+	conn        net.Conn
+	scanner     *bufio.Scanner
+	idleTimeout atomic.Int64
+}
+
+func newTcpTransport(conn net.Conn) *tcpTransport {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxMessageSize)
+	return &tcpTransport{conn: conn, scanner: scanner}
+}
+
+// Read returns the next non-empty line
+func (t *tcpTransport) Read() ([]byte, error) {
+	for t.scanner.Scan() {
+		t.extendReadDeadline()
+		if line := t.scanner.Bytes(); len(line) > 0 {
+			return append([]byte(nil), line...), nil
+		}
+	}
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Write sends the message as a line, the data belongs to the caller and is copied
+func (t *tcpTransport) Write(data []byte) error {
+	line := make([]byte, len(data)+1)
+	copy(line, data)
+	line[len(data)] = '\n'
+	_, err := t.conn.Write(line)
+	return err
+}
+
+func (t *tcpTransport) SetWriteDeadline(deadline time.Time) error {
+	return t.conn.SetWriteDeadline(deadline)
+}
+
+func (t *tcpTransport) SetIdleTimeout(timeout time.Duration) {
+	t.idleTimeout.Store(int64(timeout))
+	t.extendReadDeadline()
+}
+
+func (t *tcpTransport) extendReadDeadline() {
+	timeout := time.Duration(t.idleTimeout.Load())
+	if timeout == 0 {
+		_ = t.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	_ = t.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func (t *tcpTransport) Heartbeat() error {
+	return nil
+}
+
+func (t *tcpTransport) Close() error {
+	return t.conn.Close()
+}
+
+// ListenTCP accepts octapods speaking newline delimited JSON, for clients without a websocket library.
+// It only returns when the listener fails.
+func (oh *OctapodHandler) ListenTCP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return oh.ServeTCP(listener)
+}
+
+// ServeTCP accepts octapods on a listener that is already bound, see ListenTCP. It closes the listener when it fails.
+func (oh *OctapodHandler) ServeTCP(listener net.Listener) error {
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go oh.handleTcpJoin(conn)
+	}
+}
+
+func (oh *OctapodHandler) handleTcpJoin(conn net.Conn) {
+	transport := newTcpTransport(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(tcpJoinTimeout))
+	line, err := transport.Read()
+	if err != nil {
+		log.Println("TCP join failed:", err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	var request tcpJoin
+	if err := json.Unmarshal(line, &request); err != nil {
+		refuseTcpJoin(transport, "join line must be {\"id\": ..., \"team\": ...}")
+		return
+	}
+
+	oh.mu.Lock()
+	status, msg := oh.checkJoin(request.Id, request.Team)
+	if status == 0 {
+		log.Println("New TCP connection established from", conn.RemoteAddr())
+		oh.join(request.Id, request.Team, transport, model.CodecFor(model.JsonSubprotocol))
+	}
+	oh.mu.Unlock()
+
+	// The refusal may wait on a client that doesn't read, joins and ticks must not wait with it
+	if status != 0 {
+		refuseTcpJoin(transport, msg)
+	}
+}
+
+// refuseTcpJoin explains the refusal in a versioned error, the client never got to say hello
+func refuseTcpJoin(transport *tcpTransport, reason string) {
+	data, _ := json.Marshal(model.Envelope{
+		Type: model.MsgError,
+		V:    model.ProtocolVersion,
+		Data: model.ErrorMessage{Code: model.JoinRefused, Message: reason},
+	})
+	_ = transport.SetWriteDeadline(time.Now().Add(tcpJoinTimeout))
+	_ = transport.Write(data)
+	_ = transport.Close()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"gbccsclub/octopod-challenge/internal/model"
+	"log"
+)
+
+// TeamConfig enables relaying messages between octapods of the same team
+type TeamConfig struct {
+	Enabled bool
+	// MessageLimit is the maximum encoded size of the message an octapod can send per tick
+	MessageLimit int
+}
+
+func (c *Config) teamConfig() TeamConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return TeamConfig{
+		Enabled:      c.TeamMode,
+		MessageLimit: c.TeamMessageLimit,
+	}
+}
+
+// relayTeamMessages hands the messages sent this tick to the teammates of each sender
+func relayTeamMessages(octapods map[string]*model.Octapod, teams TeamConfig) {
+	outbox := make(map[string][]model.TeamMessage)
+	for _, octapod := range octapods {
+		message := octapod.ConsumeTeamMessage()
+		if message == nil || !teams.Enabled || octapod.GetTeam() == "" {
+			continue
+		}
+
+		if size := message.Size(); size > teams.MessageLimit {
+			log.Printf("Dropping team message from %s, %d bytes over the %d byte limit\n", octapod.GetId(), size, teams.MessageLimit)
+			continue
+		}
+		outbox[octapod.GetTeam()] = append(outbox[octapod.GetTeam()], *message)
+	}
+
+	for _, octapod := range octapods {
+		messages := outbox[octapod.GetTeam()]
+		if len(messages) == 0 {
+			continue
+		}
+
+		received := make([]model.TeamMessage, 0, len(messages))
+		for _, message := range messages {
+			if message.From != octapod.GetId() {
+				received = append(received, message)
+			}
+		}
+		octapod.Deliver(received)
+	}
+}
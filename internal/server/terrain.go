@@ -0,0 +1,118 @@
+package server
+
+import (
+	"container/heap"
+	"gbccsclub/octopod-challenge/pkg"
+)
+
+// layout is the maze with every door open, for the checks that must hold all round
+type layout struct {
+	*Maze
+	hasKey bool
+}
+
+func (l layout) IsAvailable(point pkg.Vector) bool {
+	return l.isPassage(point)
+}
+
+func (l layout) CanEnter(from pkg.Vector, to pkg.Vector) bool {
+	return l.isPassage(to) && l.canStep(from, to, l.hasKey)
+}
+
+// placeTerrain places the traps and ice that keep the maze solvable, then the mud
+func (m *Maze) placeTerrain(candidates *[]pkg.Vector, config TileConfig) {
+	for i := 0; i < config.Traps; i++ {
+		if position, ok := takeCell(candidates, nil); ok {
+			m.placeIfSolvable(pkg.Tile{Vector: position, Kind: pkg.Trap})
+		}
+	}
+
+	for i := 0; i < config.Ice; i++ {
+		if position, ok := takeCell(candidates, nil); ok {
+			m.placeIfSolvable(pkg.Tile{Vector: position, Kind: pkg.Ice})
+		}
+	}
+
+	for i := 0; i < config.Mud; i++ {
+		if position, ok := takeCell(candidates, nil); ok {
+			m.tiles[position] = pkg.Tile{Vector: position, Kind: pkg.Mud, Cost: config.MudCost}
+		}
+	}
+}
+
+// MudCost returns the extra ticks an octapod entering the point is held for
+func (m *Maze) MudCost(point pkg.Vector) int {
+	if tile, ok := m.tiles[point]; ok && tile.Kind == pkg.Mud {
+		return tile.Cost
+	}
+	return 0
+}
+
+// ShortestPathCost returns the fewest ticks from the start to the exit, counting the time
+// spent in mud, or -1 when there is no path. Doors are taken as open and the key as held.
+func (m *Maze) ShortestPathCost() int {
+	world := layout{m, true}
+	start := pkg.ZeroVec2()
+	costs := map[pkg.Vector]int{start: 0}
+	queue := &pathQueue{{position: start, cost: 0}}
+
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(pathStep)
+		if current.cost > costs[current.position] {
+			continue
+		}
+		if m.IsSolved(current.position) {
+			return current.cost
+		}
+
+		for _, direction := range m.directions() {
+			next := m.step(current.position, direction)
+			if !world.CanEnter(current.position, next) {
+				continue
+			}
+			next = m.Enter(world, current.position, next)
+
+			cost := current.cost + 1 + m.MudCost(next)
+			if known, ok := costs[next]; ok && known <= cost {
+				continue
+			}
+			costs[next] = cost
+			heap.Push(queue, pathStep{position: next, cost: cost})
+		}
+	}
+	return -1
+}
+
+type pathStep struct {
+	position pkg.Vector
+	cost     int
+}
+
+// pathQueue is a min heap of path steps by cost
+type pathQueue []pathStep
+
+func (q pathQueue) Len() int           { return len(q) }
+func (q pathQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *pathQueue) Push(x any) {
+	*q = append(*q, x.(pathStep))
+}
+
+func (q *pathQueue) Pop() any {
+	old := *q
+	step := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return step
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"errors"
+	"gbccsclub/octopod-challenge/internal/model"
+	"gbccsclub/octopod-challenge/pkg"
+	"math/rand"
+)
+
+// TileConfig is how many special tiles are placed at the start of each round
+type TileConfig struct {
+	Coins       int
+	Keys        int
+	Locks       int
+	OneWays     int
+	Teleporters int
+
+	// Terrain, see terrain.go
+	Mud     int
+	MudCost int
+	Ice     int
+	Traps   int
+
+	// RequireKey places a key even without locks, the objectives need one to solve
+	RequireKey bool
+}
+
+func (c *Config) tileConfig() TileConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return TileConfig{
+		Coins:       c.CoinCount,
+		Keys:        c.KeyCount,
+		Locks:       c.LockCount,
+		OneWays:     c.OneWayCount,
+		Teleporters: c.TeleporterCount,
+		Mud:         c.MudCount,
+		MudCost:     c.MudCost,
+		Ice:         c.IceCount,
+		Traps:       c.TrapCount,
+		RequireKey:  c.SolveRequiresKey,
+	}
+}
+
+// Objectives decide when an octapod counts as solved and how the round is scored
+type Objectives struct {
+	RequireKey bool
+	MinCoins   int
+	CoinScore  int
+	SolveScore int
+	// PathScore is scaled by how close the solve came to the weighted shortest path
+	PathScore int
+}
+
+func (c *Config) objectives() Objectives {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Objectives{
+		RequireKey: c.SolveRequiresKey,
+		MinCoins:   c.SolveMinCoins,
+		CoinScore:  c.CoinScore,
+		SolveScore: c.SolveScore,
+		PathScore:  c.PathScore,
+	}
+}
+
+// IsSolved reports whether the octapod reached the exit with everything it needs
+func (o Objectives) IsSolved(maze *Maze, position pkg.Vector, inventory model.Inventory) bool {
+	if !maze.IsSolved(position) {
+		return false
+	}
+	if o.RequireKey && !inventory.HasKey {
+		return false
+	}
+	return inventory.Coins >= o.MinCoins
+}
+
+// Score adds up the coins and the solve. SolveTicks is how long the solve took, 0 when unsolved,
+// and optimalCost is the weighted shortest path from the start to the exit.
+func (o Objectives) Score(inventory model.Inventory, solveTicks int, optimalCost int) int {
+	score := inventory.Coins * o.CoinScore
+	if solveTicks > 0 {
+		score += o.SolveScore
+		if optimalCost > 0 {
+			score += o.PathScore * min(optimalCost, solveTicks) / solveTicks
+		}
+	}
+	return score
+}
+
+// CanEnter checks the step against walls, closed doors, locked doors and one-way tiles
+func (m *Maze) CanEnter(from pkg.Vector, to pkg.Vector) bool {
+	return m.IsAvailable(to) && m.canStep(from, to, false)
+}
+
+// keyholder is the maze as seen by an octapod holding a key
+type keyholder struct {
+	*Maze
+}
+
+func (k keyholder) CanEnter(from pkg.Vector, to pkg.Vector) bool {
+	return k.IsAvailable(to) && k.canStep(from, to, true)
+}
+
+// canStep checks the step against the stairs and the tiles only
+func (m *Maze) canStep(from pkg.Vector, to pkg.Vector, hasKey bool) bool {
+	if from.Z != to.Z && !m.hasStairs(from, to) {
+		return false
+	}
+
+	tile, ok := m.tiles[to]
+	if !ok {
+		return true
+	}
+	switch tile.Kind {
+	case pkg.Lock:
+		return hasKey
+	case pkg.OneWay:
+		return m.step(from, *tile.Direction) == to
+	default:
+		return true
+	}
+}
+
+// worldFor returns the maze as the octapod with the inventory may move through it
+func (m *Maze) worldFor(inventory model.Inventory) model.World {
+	if inventory.HasKey {
+		return keyholder{m}
+	}
+	return m
+}
+
+// StopsDash ends dashes on the hazard tiles, they can't be jumped over
+func (m *Maze) StopsDash(point pkg.Vector) bool {
+	tile, ok := m.tiles[point]
+	return ok && tile.Kind.IsHazard()
+}
+
+// GetTile returns the special tile at the point, if any
+func (m *Maze) GetTile(point pkg.Vector) (pkg.Tile, bool) {
+	tile, ok := m.tiles[point]
+	return tile, ok
+}
+
+// Enter returns where a step from one cell into the next ends up,
+// sliding over ice through the world, then following the teleporter or trap it stops on
+func (m *Maze) Enter(world model.World, from pkg.Vector, to pkg.Vector) pkg.Vector {
+	if from == to {
+		return to
+	}
+
+	delta := m.grid.Delta(from, to)
+	direction := pkg.Vec2(sign(delta.X), sign(delta.Y))
+	if tile, ok := m.tiles[to]; ok && tile.Kind == pkg.Ice && direction != pkg.ZeroVec2() {
+		to = m.slide(world, to, direction)
+	}
+
+	tile, ok := m.tiles[to]
+	if !ok {
+		return to
+	}
+	switch tile.Kind {
+	case pkg.Teleporter:
+		return *tile.Target
+	case pkg.Trap:
+		return pkg.ZeroVec2()
+	default:
+		return to
+	}
+}
+
+// slide keeps stepping in the direction until the next step is blocked.
+// It never crosses more than the maze, a torus row without walls would loop forever.
+func (m *Maze) slide(world model.World, from pkg.Vector, direction pkg.Vector) pkg.Vector {
+	for i := 0; i < max(m.Width, m.Height); i++ {
+		next := m.step(from, direction)
+		if !world.CanEnter(from, next) {
+			return from
+		}
+		from = next
+	}
+	return from
+}
+
+// Collect picks up the tile at the point. Coins are taken off the maze, keys stay for the others.
+func (m *Maze) Collect(point pkg.Vector) pkg.TileKind {
+	tile, ok := m.tiles[point]
+	if !ok {
+		return ""
+	}
+	switch tile.Kind {
+	case pkg.Coin:
+		delete(m.tiles, point)
+		return pkg.Coin
+	case pkg.Key:
+		return pkg.Key
+	default:
+		return ""
+	}
+}
+
+// tilesAround returns the tiles on and next to the point
+func (m *Maze) tilesAround(point pkg.Vector) []pkg.Tile {
+	var tiles []pkg.Tile
+	for _, cell := range m.Surroundings(point) {
+		if tile, ok := m.tiles[cell.Vector]; ok {
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles
+}
+
+// PlaceTiles replaces the tiles with a new random set.
+// Tiles that would make the maze unsolvable are dropped, see isSolvable.
+// At least one key is placed when there are locked doors or the objectives require one,
+// it fails when there is no reachable cell left for it.
+func (m *Maze) PlaceTiles(config TileConfig) error {
+	m.tiles = make(map[pkg.Vector]pkg.Tile)
+	m.requireKey = false
+
+	candidates := make([]pkg.Vector, 0)
+	for _, position := range m.passages() {
+		if _, ok := m.doors[position]; !ok {
+			candidates = append(candidates, position)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	for i := 0; i < config.Locks; i++ {
+		position, ok := takeCell(&candidates, nil)
+		if !ok {
+			break
+		}
+		// Leave room for a key in front of the locked doors
+		m.tiles[position] = pkg.Tile{Vector: position, Kind: pkg.Lock}
+		if !anyCell(candidates, m.reachable(false)) {
+			delete(m.tiles, position)
+			candidates = append(candidates, position)
+		}
+	}
+
+	keys := config.Keys
+	if config.Locks > 0 || config.RequireKey {
+		keys = max(keys, 1)
+	}
+	reachable := m.reachable(false)
+	placedKey := false
+	for i := 0; i < keys; i++ {
+		if position, ok := takeCell(&candidates, reachable); ok {
+			m.tiles[position] = pkg.Tile{Vector: position, Kind: pkg.Key}
+			placedKey = true
+		}
+	}
+	if config.RequireKey && !placedKey {
+		return errors.New("no reachable cell left for the key the objectives require")
+	}
+	// The tiles placed next must not cut the octapods off the key either
+	m.requireKey = config.RequireKey
+
+	for i := 0; i < config.OneWays; i++ {
+		position, ok := takeCell(&candidates, nil)
+		if !ok {
+			break
+		}
+		directions := m.grid.Topology.Directions()
+		direction := directions[rand.Intn(len(directions))]
+		m.placeIfSolvable(pkg.Tile{Vector: position, Kind: pkg.OneWay, Direction: &direction})
+	}
+
+	for i := 0; i < config.Teleporters; i++ {
+		a, okA := takeCell(&candidates, nil)
+		b, okB := takeCell(&candidates, nil)
+		if !okA || !okB {
+			break
+		}
+		m.tiles[a] = pkg.Tile{Vector: a, Kind: pkg.Teleporter, Target: &b}
+		m.tiles[b] = pkg.Tile{Vector: b, Kind: pkg.Teleporter, Target: &a}
+		if !m.isSolvable() {
+			delete(m.tiles, a)
+			delete(m.tiles, b)
+		}
+	}
+
+	m.placeTerrain(&candidates, config)
+
+	for i := 0; i < config.Coins; i++ {
+		if position, ok := takeCell(&candidates, nil); ok {
+			m.tiles[position] = pkg.Tile{Vector: position, Kind: pkg.Coin}
+		}
+	}
+	return nil
+}
+
+// takeCell removes and returns the first candidate in the allowed set, a nil set allows any
+func takeCell(candidates *[]pkg.Vector, allowed map[pkg.Vector]bool) (pkg.Vector, bool) {
+	for i, position := range *candidates {
+		if allowed == nil || allowed[position] {
+			*candidates = append((*candidates)[:i], (*candidates)[i+1:]...)
+			return position, true
+		}
+	}
+	return pkg.Vector{}, false
+}
+
+// placeIfSolvable places the tile, unless it makes the maze unsolvable
+func (m *Maze) placeIfSolvable(tile pkg.Tile) bool {
+	m.tiles[tile.Vector] = tile
+	if !m.isSolvable() {
+		delete(m.tiles, tile.Vector)
+		return false
+	}
+	return true
+}
+
+func anyCell(candidates []pkg.Vector, allowed map[pkg.Vector]bool) bool {
+	for _, position := range candidates {
+		if allowed[position] {
+			return true
+		}
+	}
+	return false
+}
+
+// reachable returns every cell that can be reached from the start, with or without a key.
+// Doors count as open since they open again later.
+func (m *Maze) reachable(hasKey bool) map[pkg.Vector]bool {
+	return m.reachableFrom(pkg.ZeroVec2(), hasKey)
+}
+
+func (m *Maze) reachableFrom(start pkg.Vector, hasKey bool) map[pkg.Vector]bool {
+	world := layout{m, hasKey}
+	seen := map[pkg.Vector]bool{start: true}
+	queue := []pkg.Vector{start}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, direction := range m.directions() {
+			next := m.step(current, direction)
+			if !world.CanEnter(current, next) {
+				continue
+			}
+			next = m.Enter(world, current, next)
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			queue = append(queue, next)
+		}
+	}
+	return seen
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"gbccsclub/octopod-challenge/internal/model"
+	"gbccsclub/octopod-challenge/pkg"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestDashStopsOnHazards dashes six cells to the right over a hazard two cells away, which must
+// stop the dash and act on the octapod like a plain step onto it
+func TestDashStopsOnHazards(t *testing.T) {
+	start := pkg.Vec2(1, 1)
+	hazard := pkg.Vec2(3, 1)
+	target := pkg.Vec2(5, 5)
+
+	tests := []struct {
+		tile     pkg.Tile
+		expected pkg.Vector
+	}{
+		{pkg.Tile{Vector: hazard, Kind: pkg.Trap}, pkg.ZeroVec2()},
+		{pkg.Tile{Vector: hazard, Kind: pkg.Mud, Cost: 2}, hazard},
+		{pkg.Tile{Vector: hazard, Kind: pkg.Teleporter, Target: &target}, target},
+		// Ice slides on to the edge of the open maze
+		{pkg.Tile{Vector: hazard, Kind: pkg.Ice}, pkg.Vec2(9, 1)},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.tile.Kind), func(t *testing.T) {
+			maze := NewMaze(10, 10, 1, pkg.Square)
+			maze.tiles[hazard] = test.tile
+
+			oh := NewOctapodHandler()
+			octapod := dash(t, oh, maze, start, 6)
+			if position := octapod.GetPosition(); position != test.expected {
+				t.Errorf("expected the dash to end on %v, got %v", test.expected, position)
+			}
+			if test.tile.Kind == pkg.Mud {
+				// Stuck in the mud, the next tick is spent in place
+				oh.UpdateAll(maze, model.ActionRules{Points: 10, DashCost: 1}, CollisionNone, Objectives{})
+				if position := octapod.GetPosition(); position != hazard {
+					t.Errorf("expected the octapod to stay in the mud, got %v", position)
+				}
+			}
+		})
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		maze := NewMaze(10, 10, 1, pkg.Square)
+		maze.tiles[hazard] = pkg.Tile{Vector: hazard, Kind: pkg.Coin}
+
+		octapod := dash(t, NewOctapodHandler(), maze, start, 6)
+		if position := octapod.GetPosition(); position != pkg.Vec2(7, 1) {
+			t.Errorf("expected the dash to pass over the coin, got %v", position)
+		}
+	})
+}
+
+// dash joins an octapod on the start and plays a single tick where it dashes to the right
+func dash(t *testing.T, oh *OctapodHandler, maze *Maze, start pkg.Vector, distance int) *model.Octapod {
+	rules := model.ActionRules{Points: 10, DashCost: 1}
+	oh.round = model.RoundInfo{Width: maze.Width, Height: maze.Height, Floors: maze.Floors, Topology: pkg.Square, Rules: rules}
+
+	octapod := planMove(t, oh, "dasher", start, model.MoveMessage{MoveDirection: model.Right, Action: model.Dash, Distance: distance})
+	oh.UpdateAll(maze, rules, CollisionNone, Objectives{})
+	return octapod
+}
+
+// planMove joins an octapod on the start and sends the move for the next tick, the tick is left to the caller
+func planMove(t *testing.T, oh *OctapodHandler, id string, start pkg.Vector, move model.MoveMessage) *model.Octapod {
+	client, conn := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	oh.mu.Lock()
+	octapod := oh.join(id, "", newTcpTransport(conn), model.CodecFor(model.JsonSubprotocol))
+	oh.mu.Unlock()
+	octapod.Reset(start)
+
+	if err := octapod.Ping("tick", pkg.NewSensor(false, false, false, false), nil, model.Exploring); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(client)
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+
+	move.TickId = "tick"
+	data, _ := json.Marshal(move)
+	if _, err := client.Write(append(data, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); octapod.IsAwaitingMove(); {
+		if time.Now().After(deadline) {
+			t.Fatal("move was not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return octapod
+}
+
+// TestCoinGoesToLowestId has two octapods step onto the same coin in one tick,
+// the lowest id must take it whatever order the octapods are stored in
+func TestCoinGoesToLowestId(t *testing.T) {
+	coin := pkg.Vec2(2, 1)
+	rules := model.ActionRules{Points: 10, DashCost: 1}
+
+	for i := 0; i < 20; i++ {
+		maze := NewMaze(10, 10, 1, pkg.Square)
+		maze.tiles[coin] = pkg.Tile{Vector: coin, Kind: pkg.Coin}
+
+		oh := NewOctapodHandler()
+		oh.round = model.RoundInfo{Width: maze.Width, Height: maze.Height, Floors: maze.Floors, Topology: pkg.Square, Rules: rules}
+		b := planMove(t, oh, "b", pkg.Vec2(1, 1), model.MoveMessage{MoveDirection: model.Right})
+		a := planMove(t, oh, "a", pkg.Vec2(3, 1), model.MoveMessage{MoveDirection: model.Left})
+		oh.UpdateAll(maze, rules, CollisionNone, Objectives{})
+
+		if a.GetInventory().Coins != 1 || b.GetInventory().Coins != 0 {
+			t.Fatalf("expected a to take the coin, a has %d and b has %d", a.GetInventory().Coins, b.GetInventory().Coins)
+		}
+	}
+}
+
+// TestPlaceTilesRequiredKey checks a required key is always reachable, and that a maze
+// without room for it fails instead of starting a round nobody can solve
+func TestPlaceTilesRequiredKey(t *testing.T) {
+	t.Run("reachable", func(t *testing.T) {
+		config := TileConfig{Keys: 1, OneWays: 20, Teleporters: 5, Ice: 10, Traps: 10, RequireKey: true}
+		for i := 0; i < 20; i++ {
+			maze := NewMaze(10, 10, 1, pkg.Square)
+			if err := maze.PlaceTiles(config); err != nil {
+				t.Fatal(err)
+			}
+
+			reachable := maze.reachable(false)
+			found := false
+			for position, tile := range maze.tiles {
+				found = found || tile.Kind == pkg.Key && reachable[position]
+			}
+			if !found {
+				t.Fatal("expected a key within reach of the start")
+			}
+		}
+	})
+
+	t.Run("no room", func(t *testing.T) {
+		// Only the start and the exit, neither takes tiles
+		maze := NewMaze(2, 1, 1, pkg.Square)
+		if err := maze.PlaceTiles(TileConfig{Keys: 1, RequireKey: true}); err == nil {
+			t.Error("expected the required key not to fit")
+		}
+		if err := maze.PlaceTiles(TileConfig{Keys: 1}); err != nil {
+			t.Errorf("expected optional keys to be skipped, got %v", err)
+		}
+	})
+}
@@ -55,15 +55,34 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strings"
 )
 
 func main() {
 	_ = godotenv.Load(".env")
 
+	configPath := "config.yaml"
+	if os.Getenv("CONFIG_FILE") != "" {
+		configPath = os.Getenv("CONFIG_FILE")
+	}
+
+	store := server.NewConfigStore(configPath)
+	config, err := server.LoadConfig(store)
+	if err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+
 	router := gin.Default()
+	// The admin lockout is keyed on the client IP, X-Forwarded-For is only read from the proxies listed
+	var trustedProxies []string
+	if os.Getenv("TRUSTED_PROXIES") != "" {
+		trustedProxies = strings.Split(os.Getenv("TRUSTED_PROXIES"), ",")
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 	templ := web.NewTemplates()
-	config := server.NewConfig()
-	lobby := server.NewLobby(config)
+	lobby := server.NewLobby(config, store)
 
 	// ==================== Static Routes ====================
 
@@ -71,29 +90,134 @@ func main() {
 		templ.Render(c.Writer, "index", nil)
 	})
 
-	router.GET("/admin", func(c *gin.Context) {
+	router.GET("/admin/login", func(c *gin.Context) {
+		lobby.AdminHandler.HandleGetLogin(c, templ)
+	})
+
+	router.POST("/admin/login", func(c *gin.Context) {
+		lobby.AdminHandler.HandleLogin(c, templ)
+	})
+
+	// ==================== Admin Routes ====================
+
+	admin := router.Group("/admin", lobby.AdminHandler.RequireAuth)
+
+	admin.GET("", func(c *gin.Context) {
 		lobby.AdminHandler.HandleGetConfig(c, templ)
 	})
 
-	router.POST("/admin/update", func(c *gin.Context) {
+	admin.POST("/logout", lobby.AdminHandler.HandleLogout)
+
+	// ==================== Admin API Routes ====================
+
+	api := admin.Group("/api")
+
+	api.GET("/config", lobby.AdminHandler.HandleApiGetConfig)
+
+	api.PATCH("/config", func(c *gin.Context) {
 		lobby.AdminHandler.HandleUpdateConfig(c, templ, lobby)
 	})
 
+	api.POST("/restart", func(c *gin.Context) {
+		lobby.AdminHandler.HandleRestart(c, templ, lobby)
+	})
+
+	api.POST("/pause", func(c *gin.Context) {
+		lobby.AdminHandler.HandlePause(c, templ, lobby)
+	})
+
+	api.POST("/resume", func(c *gin.Context) {
+		lobby.AdminHandler.HandleResume(c, templ, lobby)
+	})
+
+	api.POST("/step", func(c *gin.Context) {
+		lobby.AdminHandler.HandleStep(c, templ, lobby)
+	})
+
+	api.GET("/status", func(c *gin.Context) {
+		lobby.AdminHandler.HandleGetStatus(c, templ, lobby)
+	})
+
+	api.GET("/octapods", func(c *gin.Context) {
+		lobby.AdminHandler.HandleListOctapods(c, templ, lobby)
+	})
+
+	api.POST("/octapods/:id/kick", func(c *gin.Context) {
+		lobby.AdminHandler.HandleKick(c, templ, lobby)
+	})
+
+	api.GET("/bans", func(c *gin.Context) {
+		lobby.AdminHandler.HandleListBans(c, lobby)
+	})
+
+	api.PUT("/bans/:id", func(c *gin.Context) {
+		lobby.AdminHandler.HandleBan(c, templ, lobby)
+	})
+
+	api.DELETE("/bans/:id", func(c *gin.Context) {
+		lobby.AdminHandler.HandleUnban(c, templ, lobby)
+	})
+
+	api.GET("/rounds", func(c *gin.Context) {
+		lobby.AdminHandler.HandleListRounds(c, templ, lobby)
+	})
+
 	// ==================== Websocket Routes ====================
 
 	router.GET("/join", func(c *gin.Context) {
 		lobby.OctapodHandler.HandleJoin(c)
 	})
 
+	router.GET("/spectate", func(c *gin.Context) {
+		lobby.Spectators.HandleSpectate(c)
+	})
+
+	// ==================== Poll Routes ====================
+
+	router.POST("/poll/join", func(c *gin.Context) {
+		lobby.OctapodHandler.HandlePollJoin(c)
+	})
+
+	router.GET("/poll/:session", func(c *gin.Context) {
+		lobby.OctapodHandler.HandlePoll(c)
+	})
+
+	router.POST("/poll/:session", func(c *gin.Context) {
+		lobby.OctapodHandler.HandlePollSubmit(c)
+	})
+
+	router.DELETE("/poll/:session", func(c *gin.Context) {
+		lobby.OctapodHandler.HandlePollLeave(c)
+	})
+
 	lobby.Start()
 
+	var tcpPort = "3001"
+	if os.Getenv("TCP_PORT") != "" {
+		tcpPort = os.Getenv("TCP_PORT")
+	}
+
+	go func() {
+		log.Println("Accepting TCP octapods on port", tcpPort)
+		err := lobby.OctapodHandler.ListenTCP(":" + tcpPort)
+		if err != nil {
+			log.Println("TCP listener stopped:", err)
+		}
+	}()
+
+	go store.Watch(func(update server.ConfigUpdate) {
+		if _, err := lobby.ApplyConfig(update); err != nil {
+			log.Println("Ignoring invalid config file:", err)
+		}
+	})
+
 	var port = "3000"
 	if os.Getenv("PORT") != "" {
 		port = os.Getenv("PORT")
 	}
 
 	log.Println("Starting a lobby server on port", port)
-	err := router.Run(":" + port)
+	err = router.Run(":" + port)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,23 @@
+package pkg
+
+import "sort"
+
+// Cell is a maze cell discovered by an octapod
+type Cell struct {
+	Vector
+	Wall bool     `json:"wall"`
+	Tile TileKind `json:"tile,omitempty"`
+}
+
+// SortCells orders cells floor by floor from the ground up, then row by row, top to bottom
+func SortCells(cells []Cell) {
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Z != cells[j].Z {
+			return cells[i].Z < cells[j].Z
+		}
+		if cells[i].Y != cells[j].Y {
+			return cells[i].Y < cells[j].Y
+		}
+		return cells[i].X < cells[j].X
+	})
+}
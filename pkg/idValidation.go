@@ -11,7 +11,7 @@ func IsValidID(id string) (bool, string) {
 		return false, "ID must be 3–20 characters and only contain letters, numbers, - or _"
 	}
 
-	if !goaway.IsProfane(id) {
+	if goaway.IsProfane(id) {
 		return false, "Please use appropriate language."
 	}
 	return true, ""
@@ -7,8 +7,51 @@ type Sensor struct {
 	Right bool `json:"right"`
 	Up    bool `json:"up"`
 	Down  bool `json:"down"`
+
+	// Topology is set when the maze is not a square grid. Hex grids block Up and Down
+	// and fill in the diagonals, which point to the neighbours above and below.
+	Topology  Topology `json:"topology,omitempty"`
+	UpLeft    *bool    `json:"upLeft,omitempty"`
+	UpRight   *bool    `json:"upRight,omitempty"`
+	DownLeft  *bool    `json:"downLeft,omitempty"`
+	DownRight *bool    `json:"downRight,omitempty"`
+
+	// Ascend and Descend are set in multi-level mazes, true when there are no stairs that way
+	Ascend  *bool `json:"ascend,omitempty"`
+	Descend *bool `json:"descend,omitempty"`
+
+	// Optional sensors, only set when enabled for the round
+
+	// Distances counts the open cells before the nearest wall in each direction
+	Distances *Distances `json:"distances,omitempty"`
+	// Compass is the bearing to the exit in degrees, clockwise from up
+	Compass *float64 `json:"compass,omitempty"`
+	// Signal rises from 0 to 1 as the octapod gets closer to the exit, with some noise
+	Signal *float64 `json:"signal,omitempty"`
+	// Nearby holds the offsets of other octapods within the proximity radius
+	Nearby []Vector `json:"nearby,omitempty"`
+
+	// Scan holds the cells around the octapod after a scan action
+	Scan []Cell `json:"scan,omitempty"`
+
+	// Tiles holds the special tiles on and next to the octapod
+	Tiles []Tile `json:"tiles,omitempty"`
+}
+
+type Distances struct {
+	Left  int `json:"left"`
+	Right int `json:"right"`
+	Up    int `json:"up"`
+	Down  int `json:"down"`
+
+	// Diagonals are only set on hex grids
+	UpLeft    *int `json:"upLeft,omitempty"`
+	UpRight   *int `json:"upRight,omitempty"`
+	DownLeft  *int `json:"downLeft,omitempty"`
+	DownRight *int `json:"downRight,omitempty"`
 }
 
+// IsBlocked reads the four cardinal directions of a square grid
 func (s Sensor) IsBlocked(direction Vector) bool {
 	switch direction {
 	case Vec2Up():
@@ -25,5 +68,5 @@ func (s Sensor) IsBlocked(direction Vector) bool {
 }
 
 func NewSensor(left, right, up, down bool) *Sensor {
-	return &Sensor{left, right, up, down}
+	return &Sensor{Left: left, Right: right, Up: up, Down: down}
 }
@@ -0,0 +1,46 @@
+package pkg
+
+// TileKind is a special maze cell, plain passages have no tile
+type TileKind string
+
+const (
+	// Coin is picked up by the first octapod to step on it
+	Coin TileKind = "coin"
+	// Key lets the octapod through locked doors, every octapod picks up its own
+	Key TileKind = "key"
+	// Lock is a locked door, only octapods holding a key may enter
+	Lock TileKind = "lock"
+	// OneWay may only be entered moving in its direction
+	OneWay TileKind = "oneway"
+	// Teleporter sends the octapod entering it to its target
+	Teleporter TileKind = "teleporter"
+
+	// Mud holds the octapod entering it for extra ticks
+	Mud TileKind = "mud"
+	// Ice slides the octapod entering it onwards until it is blocked
+	Ice TileKind = "ice"
+	// Trap sends the octapod entering it back to the start
+	Trap TileKind = "trap"
+)
+
+// IsHazard reports whether the tile acts on the octapod entering it, a dash stops on hazards
+func (k TileKind) IsHazard() bool {
+	switch k {
+	case Teleporter, Mud, Ice, Trap:
+		return true
+	default:
+		return false
+	}
+}
+
+type Tile struct {
+	Vector
+	Kind TileKind `json:"kind"`
+
+	// Direction is set for one-way tiles
+	Direction *Vector `json:"direction,omitempty"`
+	// Target is set for teleporters
+	Target *Vector `json:"target,omitempty"`
+	// Cost is the extra ticks spent in mud
+	Cost int `json:"cost,omitempty"`
+}
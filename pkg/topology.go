@@ -0,0 +1,103 @@
+package pkg
+
+import "math"
+
+// Topology is the shape of the maze grid
+type Topology string
+
+const (
+	// Square is the classic rectangle where every cell has four neighbours
+	Square Topology = "square"
+	// Hex uses axial coordinates, x runs along a row and y down the rows,
+	// every cell has six neighbours and rows lean half a cell to the right
+	Hex Topology = "hex"
+	// Torus is a square grid that wraps around at the edges
+	Torus Topology = "torus"
+)
+
+func (t Topology) IsValid() bool {
+	return t == Square || t == Hex || t == Torus
+}
+
+// Directions lists the steps between neighbouring cells
+func (t Topology) Directions() []Vector {
+	if t == Hex {
+		return []Vector{
+			Vec2Left(), Vec2Right(),
+			Vec2(0, -1), Vec2(1, -1),
+			Vec2(-1, 1), Vec2(0, 1),
+		}
+	}
+	return []Vector{Vec2Up(), Vec2Down(), Vec2Left(), Vec2Right()}
+}
+
+// Grid is a topology of a given size
+type Grid struct {
+	Topology Topology `json:"topology"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	// Floors are stacked on top of each other, a single floor when 0
+	Floors int `json:"floors,omitempty"`
+}
+
+func (g Grid) Contains(point Vector) bool {
+	return point.X >= 0 && point.X < g.Width && point.Y >= 0 && point.Y < g.Height &&
+		point.Z >= 0 && point.Z < max(g.Floors, 1)
+}
+
+// Step moves from the point by the direction, wrapping around on a torus.
+// False when the step leaves the grid.
+func (g Grid) Step(point Vector, direction Vector) (Vector, bool) {
+	next := point.Add(direction)
+	if g.Topology == Torus {
+		next = Vec3(mod(next.X, g.Width), mod(next.Y, g.Height), next.Z)
+	}
+	return next, g.Contains(next)
+}
+
+// Delta returns the offset from one point to another, the shortest way around on a torus
+func (g Grid) Delta(from Vector, to Vector) Vector {
+	delta := to.Sub(from)
+	if g.Topology == Torus {
+		delta = Vec3(wrapDelta(delta.X, g.Width), wrapDelta(delta.Y, g.Height), delta.Z)
+	}
+	return delta
+}
+
+// Distance is the number of steps between the points, ignoring walls and counting floors as steps
+func (g Grid) Distance(from Vector, to Vector) int {
+	delta := g.Delta(from, to)
+	if g.Topology == Hex {
+		return (Abs(delta.X)+Abs(delta.Y)+Abs(delta.X+delta.Y))/2 + Abs(delta.Z)
+	}
+	return Abs(delta.X) + Abs(delta.Y) + Abs(delta.Z)
+}
+
+// Offset returns the offset between the points on screen, with y growing downwards and floors ignored
+func (g Grid) Offset(from Vector, to Vector) (float64, float64) {
+	delta := g.Delta(from, to)
+	if g.Topology == Hex {
+		return float64(delta.X) + float64(delta.Y)/2, float64(delta.Y) * math.Sqrt(3) / 2
+	}
+	return float64(delta.X), float64(delta.Y)
+}
+
+func mod(x int, n int) int {
+	return ((x % n) + n) % n
+}
+
+func wrapDelta(d int, n int) int {
+	d = mod(d, n)
+	if d > n/2 {
+		d -= n
+	}
+	return d
+}
+
+// Abs returns the absolute value of x
+func Abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -3,22 +3,32 @@ package pkg
 type Vector struct {
 	X int `json:"x"`
 	Y int `json:"y"`
+	// Z is the floor, only used by multi-level mazes
+	Z int `json:"z,omitempty"`
 }
 
 func (v Vector) Add(other Vector) Vector {
-	return Vec2(v.X+other.X, v.Y+other.Y)
+	return Vec3(v.X+other.X, v.Y+other.Y, v.Z+other.Z)
+}
+
+func (v Vector) Sub(other Vector) Vector {
+	return Vec3(v.X-other.X, v.Y-other.Y, v.Z-other.Z)
 }
 
 func Vec2(x, y int) Vector {
-	return Vector{x, y}
+	return Vector{x, y, 0}
+}
+
+func Vec3(x, y, z int) Vector {
+	return Vector{x, y, z}
 }
 
 func ZeroVec2() Vector {
-	return Vector{0, 0}
+	return Vector{0, 0, 0}
 }
 
 func (v Vector) Copy() Vector {
-	return Vector{v.X, v.Y}
+	return Vector{v.X, v.Y, v.Z}
 }
 
 func (v Vector) Up() Vector {
@@ -37,6 +47,15 @@ func (v Vector) Right() Vector {
 	return Vec2(v.X+1, v.Y)
 }
 
+// Above is the same cell one floor up
+func (v Vector) Above() Vector {
+	return Vec3(v.X, v.Y, v.Z+1)
+}
+
+func (v Vector) Below() Vector {
+	return Vec3(v.X, v.Y, v.Z-1)
+}
+
 func Vec2Up() Vector {
 	return Vec2(0, -1)
 }